@@ -4,16 +4,13 @@ go 1.23
 
 require (
 	github.com/amacneil/dbmate v1.16.2
+	github.com/go-sql-driver/mysql v1.7.0
+	github.com/lib/pq v1.10.7
+	github.com/mattn/go-sqlite3 v1.14.24
 	github.com/onsi/ginkgo v1.16.5
 	github.com/onsi/gomega v1.19.0
 )
 
-require (
-	github.com/go-sql-driver/mysql v1.7.0 // indirect
-	github.com/lib/pq v1.10.7 // indirect
-	github.com/mattn/go-sqlite3 v1.14.24 // indirect
-)
-
 require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/nxadm/tail v1.4.8 // indirect
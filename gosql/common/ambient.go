@@ -0,0 +1,26 @@
+package common
+
+import "context"
+
+type txKey struct{}
+
+// ContextWithTx returns context which makes engine methods to run queries
+// inside tx, Transaction passes such context to its callback
+func ContextWithTx(ctx context.Context, tx *Tx) context.Context {
+	return context.WithValue(ctx, txKey{}, tx)
+}
+
+// TxFromContext returns transaction stored in context or nil
+func TxFromContext(ctx context.Context) *Tx {
+	tx, _ := ctx.Value(txKey{}).(*Tx)
+	return tx
+}
+
+// ContextTx returns transaction stored in context when it was started by d,
+// transactions of other engines are ignored
+func (d *DBMethods) ContextTx(ctx context.Context) *Tx {
+	if tx := TxFromContext(ctx); tx != nil && tx.db == d {
+		return tx
+	}
+	return nil
+}
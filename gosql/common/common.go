@@ -3,8 +3,10 @@ package common
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
 	"os"
 	"reflect"
@@ -17,6 +19,7 @@ import (
 	_ "github.com/amacneil/dbmate/pkg/driver/mysql"
 	_ "github.com/amacneil/dbmate/pkg/driver/postgres"
 	_ "github.com/amacneil/dbmate/pkg/driver/sqlite"
+	gosqlerrors "github.com/vladimirok5959/golang-sql/gosql/errors"
 	"golang.org/x/exp/slices"
 )
 
@@ -30,8 +33,14 @@ type Engine interface {
 	Exec(ctx context.Context, query string, args ...any) (sql.Result, error)
 	ExecPrepared(ctx context.Context, prep *Prepared) (sql.Result, error)
 	InsertRow(ctx context.Context, row any) error
+	Lock(ctx context.Context, name string) error
+	LockLost(ctx context.Context, name string) <-chan struct{}
+	NamedExec(ctx context.Context, query string, arg any) (sql.Result, error)
+	NamedQuery(ctx context.Context, query string, arg any) (*Rows, error)
+	NamedQueryRow(ctx context.Context, query string, arg any) *Row
+	Paginate(ctx context.Context, query string, page Page, callback func(ctx context.Context, rows *Rows) error, args ...any) (*PageResult, error)
 	Ping(context.Context) error
-	Prepare(ctx context.Context, query string) (*sql.Stmt, error)
+	Prepare(ctx context.Context, query string) (*Stmt, error)
 	PrepareSQL(query string, args ...any) *Prepared
 	Query(ctx context.Context, query string, args ...any) (*Rows, error)
 	QueryPrepared(ctx context.Context, prep *Prepared) (*Rows, error)
@@ -42,12 +51,17 @@ type Engine interface {
 	SetConnMaxLifetime(d time.Duration)
 	SetMaxIdleConns(n int)
 	SetMaxOpenConns(n int)
+	Shutdown(ctx context.Context) error
+	Stats() sql.DBStats
 	Transaction(ctx context.Context, queries func(ctx context.Context, tx *Tx) error) error
+	TransactionRetry(ctx context.Context, policy RetryPolicy, queries func(ctx context.Context, tx *Tx) error) error
+	TransactionWith(ctx context.Context, opts *sql.TxOptions, queries func(ctx context.Context, tx *Tx) error) error
+	TryLock(ctx context.Context, name string) (bool, error)
+	Unlock(ctx context.Context, name string) error
 	UpdateRow(ctx context.Context, row any) error
 	UpdateRowOnly(ctx context.Context, row any, fields ...string) error
 }
 
-var rSqlParam = regexp.MustCompile(`\$\d+`)
 var rLogSpacesAll = regexp.MustCompile(`[\s\t]+`)
 var rLogSpacesEnd = regexp.MustCompile(`[\s\t]+;$`)
 
@@ -55,7 +69,7 @@ func currentUnixTimestamp() int64 {
 	return time.Now().UTC().Unix()
 }
 
-func deleteRowByIDString(row any) string {
+func deleteRowByIDString(dialect Dialect, row any) string {
 	v := reflect.ValueOf(row).Elem()
 	t := v.Type()
 	var table string
@@ -66,11 +80,44 @@ func deleteRowByIDString(row any) string {
 			}
 		}
 	}
-	return `DELETE FROM ` + table + ` WHERE id = $1`
+	return `DELETE FROM ` + dialect.QuoteIdent(table) + ` WHERE ` + dialect.QuoteIdent("id") + ` = $1`
 }
 
 func fixQuery(query string) string {
-	return rSqlParam.ReplaceAllString(query, "?")
+	q, _ := rebindQuery(query, PlaceholderQuestion)
+	return q
+}
+
+func fixQueryArgs(dialect Dialect, query string, args []any) (string, []any, error) {
+	switch dialect.Placeholder() {
+	case PlaceholderQuestion:
+		q, positions := rebindQuery(query, PlaceholderQuestion)
+		args, err := rebindArgs(positions, args)
+		return q, args, err
+	case PlaceholderNumbered:
+		q, positions := rebindQuery(query, PlaceholderNumbered)
+		if _, err := rebindArgs(positions, args); err != nil {
+			return q, args, err
+		}
+		return q, args, nil
+	}
+	return query, args, nil
+}
+
+// fixPrepareQuery rewrites placeholders of statement which args are passed
+// when it's executed
+func fixPrepareQuery(dialect Dialect, query string) (string, error) {
+	style := dialect.Placeholder()
+	if style == PlaceholderDollar {
+		return query, nil
+	}
+	q, positions := rebindQuery(query, style)
+	for _, position := range positions {
+		if position < 1 {
+			return q, fmt.Errorf("invalid placeholder $%d", position)
+		}
+	}
+	return q, nil
 }
 
 func inArray(arr []string, str string) bool {
@@ -82,7 +129,7 @@ func inArray(arr []string, str string) bool {
 	return false
 }
 
-func insertRowString(row any) (string, []any) {
+func insertRowString(dialect Dialect, row any) (string, []any) {
 	v := reflect.ValueOf(row).Elem()
 	t := v.Type()
 	var table string
@@ -100,7 +147,7 @@ func insertRowString(row any) (string, []any) {
 		tag := t.Field(i).Tag.Get("field")
 		if tag != "" {
 			if tag != "id" {
-				fields = append(fields, tag)
+				fields = append(fields, dialect.QuoteIdent(tag))
 				values = append(values, "$"+strconv.Itoa(position))
 				if tag == "created_at" || tag == "updated_at" {
 					args = append(args, created_at)
@@ -118,7 +165,7 @@ func insertRowString(row any) (string, []any) {
 			}
 		}
 	}
-	return `INSERT INTO ` + table + ` (` + strings.Join(fields, ", ") + `) VALUES (` + strings.Join(values, ", ") + `)`, args
+	return `INSERT INTO ` + dialect.QuoteIdent(table) + ` (` + strings.Join(fields, ", ") + `) VALUES (` + strings.Join(values, ", ") + `)`, args
 }
 
 func log(w io.Writer, fname string, start time.Time, err error, tx bool, query string, args ...any) string {
@@ -175,7 +222,7 @@ func prepareSQL(query string, args ...any) *Prepared {
 	return &Prepared{query, args}
 }
 
-func queryRowByIDString(row any) string {
+func queryRowByIDString(dialect Dialect, row any) string {
 	v := reflect.ValueOf(row).Elem()
 	t := v.Type()
 	var table string
@@ -188,13 +235,13 @@ func queryRowByIDString(row any) string {
 		}
 		tag := t.Field(i).Tag.Get("field")
 		if tag != "" {
-			fields = append(fields, tag)
+			fields = append(fields, dialect.QuoteIdent(tag))
 		}
 	}
-	return `SELECT ` + strings.Join(fields, ", ") + ` FROM ` + table + ` WHERE id = $1 LIMIT 1`
+	return `SELECT ` + strings.Join(fields, ", ") + ` FROM ` + dialect.QuoteIdent(table) + ` WHERE ` + dialect.QuoteIdent("id") + ` = $1 ` + dialect.LimitOffset(1, 0)
 }
 
-func rowExistsString(row any) string {
+func rowExistsString(dialect Dialect, row any) string {
 	v := reflect.ValueOf(row).Elem()
 	t := v.Type()
 	var table string
@@ -205,7 +252,86 @@ func rowExistsString(row any) string {
 			}
 		}
 	}
-	return `SELECT 1 FROM ` + table + ` WHERE id = $1 LIMIT 1`
+	return `SELECT 1 FROM ` + dialect.QuoteIdent(table) + ` WHERE ` + dialect.QuoteIdent("id") + ` = $1 ` + dialect.LimitOffset(1, 0)
+}
+
+type rebound struct {
+	query     string
+	positions []int
+}
+
+type reboundKey struct {
+	query string
+	style PlaceholderStyle
+}
+
+var reboundQueries = newLRU[reboundKey, *rebound](queryCacheSize)
+
+// rebindArgs orders args to match "?" placeholders returned by rebindQuery,
+// so placeholders can be reused or be written out of order, args without
+// placeholder are rejected like PostgreSQL does
+func rebindArgs(positions []int, args []any) ([]any, error) {
+	if len(positions) == 0 {
+		return args, nil
+	}
+	ordered := len(positions) == len(args)
+	highest := 0
+	for i, position := range positions {
+		if position > len(args) || position < 1 {
+			return nil, fmt.Errorf("missing argument for placeholder $%d", position)
+		}
+		if position != i+1 {
+			ordered = false
+		}
+		highest = max(highest, position)
+	}
+	if len(args) > highest {
+		return nil, fmt.Errorf("extra arguments: %d, highest placeholder is $%d", len(args), highest)
+	}
+	if ordered {
+		return args, nil
+	}
+	res := make([]any, len(positions))
+	for i, position := range positions {
+		res[i] = args[position-1]
+	}
+	return res, nil
+}
+
+// rebindQuery replaces PostgreSQL placeholders with placeholders of given
+// style and returns positions of original args for each of them, query of
+// MySQL style is parsed by MySQL rules
+func rebindQuery(query string, style PlaceholderStyle) (string, []int) {
+	if !strings.Contains(query, "$") {
+		return query, nil
+	}
+	key := reboundKey{query, style}
+	if r, ok := reboundQueries.get(key); ok {
+		return r.query, r.positions
+	}
+
+	var sb strings.Builder
+	positions := []int{}
+	for _, t := range tokenize(query, style == PlaceholderQuestion) {
+		if t.kind != tokenPositional {
+			sb.WriteString(t.text)
+			continue
+		}
+		position, _ := strconv.Atoi(t.text[1:])
+		positions = append(positions, position)
+		switch style {
+		case PlaceholderQuestion:
+			sb.WriteString("?")
+		case PlaceholderNumbered:
+			sb.WriteString("?" + t.text[1:])
+		default:
+			sb.WriteString(t.text)
+		}
+	}
+
+	r := &rebound{query: sb.String(), positions: positions}
+	reboundQueries.put(key, r)
+	return r.query, r.positions
 }
 
 func scans(row any) []any {
@@ -217,7 +343,7 @@ func scans(row any) []any {
 	return res
 }
 
-func updateRowString(row any, only ...string) (string, []any) {
+func updateRowString(dialect Dialect, row any, only ...string) (string, []any) {
 	v := reflect.ValueOf(row).Elem()
 	t := v.Type()
 	var id int64
@@ -239,7 +365,7 @@ func updateRowString(row any, only ...string) (string, []any) {
 				id = v.Field(i).Int()
 			}
 			if tag != "id" && tag != "created_at" && ((len(only) == 0) || (len(only) > 0 && inArray(only, tag))) {
-				fields = append(fields, tag)
+				fields = append(fields, dialect.QuoteIdent(tag))
 				values = append(values, "$"+strconv.Itoa(position))
 				if tag == "updated_at" {
 					args = append(args, updated_at)
@@ -259,7 +385,7 @@ func updateRowString(row any, only ...string) (string, []any) {
 	}
 	sql := ""
 	args = append(args, id)
-	sql += "UPDATE " + table + " SET "
+	sql += "UPDATE " + dialect.QuoteIdent(table) + " SET "
 	for i, v := range fields {
 		sql += v + " = " + values[i]
 		if i < len(fields)-1 {
@@ -268,29 +394,55 @@ func updateRowString(row any, only ...string) (string, []any) {
 			sql += " "
 		}
 	}
-	sql += "WHERE id = " + "$" + strconv.Itoa(position)
+	sql += "WHERE " + dialect.QuoteIdent("id") + " = " + "$" + strconv.Itoa(position)
 	return sql, args
 }
 
 func ParseUrl(dbURL string) (*url.URL, error) {
+	databaseURL, _, err := ParseUrlParams(dbURL)
+	return databaseURL, err
+}
+
+// ParseUrlParams works like ParseUrl, but also returns gosql specific query
+// params (with "gosql_" prefix), which are removed from URL, so they are not
+// passed to driver
+func ParseUrlParams(dbURL string) (*url.URL, url.Values, error) {
 	databaseURL, err := url.Parse(dbURL)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse URL: %w", err)
+		return nil, nil, fmt.Errorf("unable to parse URL: %w", err)
 	}
 
 	if databaseURL.Scheme == "" {
-		return nil, fmt.Errorf("protocol scheme is not defined")
+		return nil, nil, fmt.Errorf("protocol scheme is not defined")
 	}
 
 	protocols := []string{"mysql", "postgres", "postgresql", "sqlite", "sqlite3"}
-	if !slices.Contains(protocols, databaseURL.Scheme) {
-		return nil, fmt.Errorf("unsupported protocol scheme: %s", databaseURL.Scheme)
+	if _, _, ok := LookupEngine(databaseURL.Scheme); !ok && !slices.Contains(protocols, databaseURL.Scheme) {
+		return nil, nil, fmt.Errorf("unsupported protocol scheme: %s", databaseURL.Scheme)
+	}
+
+	params := url.Values{}
+	if databaseURL.RawQuery != "" {
+		// Keep order and encoding of driver params as is
+		var rest []string
+		for _, part := range strings.Split(databaseURL.RawQuery, "&") {
+			key, value, _ := strings.Cut(part, "=")
+			if name, err := url.QueryUnescape(key); err == nil && strings.HasPrefix(name, "gosql_") {
+				if value, err = url.QueryUnescape(value); err != nil {
+					return nil, nil, fmt.Errorf("unable to parse URL: %w", err)
+				}
+				params.Add(name, value)
+				continue
+			}
+			rest = append(rest, part)
+		}
+		databaseURL.RawQuery = strings.Join(rest, "&")
 	}
 
-	return databaseURL, nil
+	return databaseURL, params, nil
 }
 
-func OpenDB(databaseURL *url.URL, migrationsDir string, skipMigration bool, debug bool) (*sql.DB, error) {
+func migrateDB(databaseURL *url.URL, migrationsDir string) error {
 	mate := dbmate.New(databaseURL)
 
 	mate.AutoDumpSchema = false
@@ -299,26 +451,189 @@ func OpenDB(databaseURL *url.URL, migrationsDir string, skipMigration bool, debu
 		mate.MigrationsDir = migrationsDir
 	}
 
-	driver, err := mate.GetDriver()
-	if err != nil {
-		return nil, fmt.Errorf("DB get driver error: %w", err)
+	if err := mate.CreateAndMigrate(); err != nil {
+		return fmt.Errorf("DB migration error: %w", err)
 	}
 
-	if !skipMigration {
-		if err := mate.CreateAndMigrate(); err != nil {
-			return nil, fmt.Errorf("DB migration error: %w", err)
+	return nil
+}
+
+// OpenConfig describes how OpenDBContext opens and prepares connections pool
+type OpenConfig struct {
+	// Debug enables logging of Open call
+	Debug bool
+
+	// Dialect is used for native migrations from MigrationsFS and for
+	// migrations of registered engine, can be nil otherwise
+	Dialect Dialect
+
+	// Factory opens connections pool, dbmate driver is used when it's nil
+	Factory EngineFactory
+
+	// Logger receives debug messages, os.Stdout is used when it's not set
+	Logger io.Writer
+
+	// MigrationsDir is dbmate migrations directory, empty means default
+	MigrationsDir string
+
+	// MigrationsFS enables native migrations instead of dbmate directory
+	MigrationsFS fs.FS
+
+	// SkipMigration disables migrations
+	SkipMigration bool
+
+	// ConnectTimeout enables Ping after opening with given timeout
+	ConnectTimeout time.Duration
+
+	// RetryInterval enables retries of open failed by connection error, it's
+	// delay before first retry, which is doubled for each next one up to
+	// RetryMaxInterval, RetryMaxAttempts limits count of attempts (zero
+	// means no limit)
+	RetryInterval    time.Duration
+	RetryMaxInterval time.Duration
+	RetryMaxAttempts int
+
+	ConnMaxIdleTime time.Duration
+	ConnMaxLifetime time.Duration
+	MaxIdleConns    int
+	MaxOpenConns    int
+}
+
+func OpenDB(databaseURL *url.URL, migrationsDir string, skipMigration bool, debug bool) (*sql.DB, error) {
+	return OpenDBContext(context.Background(), databaseURL, OpenConfig{
+		Debug:         debug,
+		MigrationsDir: migrationsDir,
+		SkipMigration: skipMigration,
+	})
+}
+
+// OpenDBContext applies migrations, opens connections pool and configures it.
+// When RetryInterval is set, attempts failed by connection error are repeated
+// with exponential backoff until database answers Ping, context is done or
+// RetryMaxAttempts are made
+func OpenDBContext(ctx context.Context, databaseURL *url.URL, cfg OpenConfig) (*sql.DB, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = os.Stdout
+	}
+
+	interval := cfg.RetryInterval
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		db, err := openDB(ctx, databaseURL, cfg, logger)
+		if err == nil || interval <= 0 || !transient(ctx, err) {
+			return db, err
+		}
+		if cfg.RetryMaxAttempts > 0 && attempt >= cfg.RetryMaxAttempts {
+			return nil, fmt.Errorf("%w (%d attempts)", err, attempt)
+		}
+
+		if cfg.Debug {
+			log(logger, "OpenRetry", start, fmt.Errorf("attempt %d failed, retry in %s: %w", attempt, interval, err), false, "")
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, fmt.Errorf("%w (%s)", err, ctx.Err())
+		case <-timer.C:
+		}
+
+		interval *= 2
+		if cfg.RetryMaxInterval > 0 && interval > cfg.RetryMaxInterval {
+			interval = cfg.RetryMaxInterval
+		}
+	}
+}
+
+// transient reports whether failed open can succeed on next attempt, it's
+// connection error or expired connect timeout
+func transient(ctx context.Context, err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+		return true
+	}
+	return gosqlerrors.IsConnectionError(err)
+}
+
+func openDB(ctx context.Context, databaseURL *url.URL, cfg OpenConfig, logger io.Writer) (*sql.DB, error) {
+	if cfg.Factory != nil && cfg.Dialect == nil {
+		return nil, fmt.Errorf("dialect of engine factory is not set")
+	}
+
+	if !cfg.SkipMigration && cfg.MigrationsFS == nil {
+		migrationURL := *databaseURL
+		if cfg.Factory != nil {
+			migrationURL.Scheme = cfg.Dialect.Name()
+		}
+		if err := migrateDB(&migrationURL, cfg.MigrationsDir); err != nil {
+			return nil, err
 		}
 	}
 
 	var db *sql.DB
+	var err error
 	start := time.Now()
-	db, err = driver.Open()
-	if debug {
-		log(os.Stdout, "Open", start, err, false, "")
+	if cfg.Factory != nil {
+		db, err = cfg.Factory(databaseURL)
+	} else {
+		db, err = openDriver(databaseURL)
+	}
+	if cfg.Debug {
+		log(logger, "Open", start, err, false, "")
 	}
 	if err != nil {
 		return nil, fmt.Errorf("DB open error: %w", err)
 	}
 
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+
+	if cfg.ConnectTimeout > 0 || cfg.RetryInterval > 0 {
+		pingCtx, cancel := ctx, context.CancelFunc(func() {})
+		if cfg.ConnectTimeout > 0 {
+			pingCtx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
+		}
+		start := time.Now()
+		err := db.PingContext(pingCtx)
+		cancel()
+		if cfg.Debug {
+			log(logger, "Ping", start, err, false, "")
+		}
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("DB connect error: %w", err)
+		}
+	}
+
+	if !cfg.SkipMigration && cfg.MigrationsFS != nil {
+		if err := Migrate(db, cfg.Dialect, cfg.MigrationsFS); err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
+
+func openDriver(databaseURL *url.URL) (*sql.DB, error) {
+	mate := dbmate.New(databaseURL)
+	mate.Log = io.Discard
+
+	driver, err := mate.GetDriver()
+	if err != nil {
+		return nil, fmt.Errorf("DB get driver error: %w", err)
+	}
+
+	return driver.Open()
+}
@@ -1,11 +1,33 @@
 package common
 
+var BindNamed = bindNamed
+var CompileNamed = compileNamed
+var CountQueryString = countQueryString
+var DecodeCursor = decodeCursor
 var DeleteRowByIDString = deleteRowByIDString
+var EncodeCursor = encodeCursor
 var FixQuery = fixQuery
 var InArray = inArray
 var InsertRowString = insertRowString
 var Log = log
+var NewLRU = newLRU[string, int]
+var PageQueryString = pageQueryString
+var ParseMigration = parseMigration
 var QueryRowByIDString = queryRowByIDString
+var RebindArgs = rebindArgs
+var RebindQuery = rebindQuery
 var RowExistsString = rowExistsString
 var Scans = scans
 var UpdateRowString = updateRowString
+
+func LRUGet(c *lru[string, int], key string) (int, bool) {
+	return c.get(key)
+}
+
+func LRULen(c *lru[string, int]) int {
+	return c.len()
+}
+
+func LRUPut(c *lru[string, int], key string, value int) {
+	c.put(key, value)
+}
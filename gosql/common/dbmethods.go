@@ -3,7 +3,9 @@ package common
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"time"
 )
@@ -11,34 +13,114 @@ import (
 type DBMethods struct {
 	DB *sql.DB
 
-	Debug  bool
-	Driver string
+	Debug   bool
+	Dialect Dialect
+	Driver  string
+
+	// Hooks are called after each call of engine and transaction methods
+	Hooks []Hook
+
+	// Logger receives debug messages, os.Stdout is used when it's not set
+	Logger io.Writer
+
+	// LockLease is lease of lock table row, DefaultLockLease is used when
+	// it's not set
+	LockLease time.Duration
+
+	// QueryTimeout limits each Exec, Query and QueryRow call when it's set
+	QueryTimeout time.Duration
+
+	inflight inflight
+	locks    locks
 }
 
-func (d *DBMethods) fixQuery(query string) string {
-	if d.Driver == "mysql" {
-		return fixQuery(query)
+// dialect returns Dialect or dialect registered for Driver when it's not set
+func (d *DBMethods) dialect() Dialect {
+	if d.Dialect != nil {
+		return d.Dialect
+	}
+	if dialect, ok := LookupDialect(d.Driver); ok {
+		return dialect
 	}
-	return query
+	return plainDialect{name: d.Driver}
 }
 
-func (d *DBMethods) log(fname string, start time.Time, err error, tx bool, query string, args ...any) {
+func (d *DBMethods) fixQuery(query string, args []any) (string, []any, error) {
+	return fixQueryArgs(d.dialect(), query, args)
+}
+
+func (d *DBMethods) log(ctx context.Context, fname string, start time.Time, err error, tx bool, query string, args ...any) {
 	if d.Debug {
-		log(os.Stdout, fname, start, err, tx, query, args...)
+		log(d.logger(), fname, start, err, tx, query, args...)
+	}
+	runHooks(ctx, d.Hooks, fname, start, err, tx, query, args...)
+}
+
+func (d *DBMethods) logger() io.Writer {
+	if d.Logger != nil {
+		return d.Logger
 	}
+	return os.Stdout
+}
+
+func (d *DBMethods) timeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d.QueryTimeout > 0 {
+		return context.WithTimeout(ctx, d.QueryTimeout)
+	}
+	return ctx, func() {}
 }
 
 func (d *DBMethods) Begin(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	mode, err := txMode(d.dialect(), opts)
+	if err != nil {
+		return nil, err
+	}
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return nil, err
+	}
 	start := time.Now()
-	tx, err := d.DB.BeginTx(ctx, opts)
-	d.log("Begin", start, err, true, "")
-	return &Tx{tx, d.Debug, d.Driver, start}, err
+	var tx *sql.Tx
+	var conn *sql.Conn
+	if mode.Start != "" {
+		if conn, err = d.DB.Conn(ctx); err == nil {
+			if _, err = conn.ExecContext(ctx, mode.Start); err != nil {
+				conn.Close()
+			}
+		}
+	} else {
+		tx, err = d.DB.BeginTx(ctx, mode.Options)
+	}
+	d.log(ctx, "Begin", start, err, true, mode.Start)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	t := &Tx{
+		db:      d,
+		tx:      tx,
+		conn:    conn,
+		reset:   mode.Reset,
+		Debug:   d.Debug,
+		Dialect: d.dialect(),
+		Driver:  d.Driver,
+		release: release,
+		ctx:     context.WithoutCancel(ctx),
+	}
+	for _, query := range mode.Begin {
+		if err := t.exec(ctx, "Begin", query); err != nil {
+			_ = t.Rollback()
+			return nil, err
+		}
+	}
+	return t, nil
 }
 
 func (d *DBMethods) Close() error {
+	d.unlockAll()
 	start := time.Now()
 	err := d.DB.Close()
-	d.log("Close", start, err, false, "")
+	d.log(context.Background(), "Close", start, err, false, "")
 	return err
 }
 
@@ -47,7 +129,7 @@ func (d *DBMethods) CurrentUnixTimestamp() int64 {
 }
 
 func (d *DBMethods) DeleteRowByID(ctx context.Context, id int64, row any) error {
-	query := deleteRowByIDString(row)
+	query := deleteRowByIDString(d.dialect(), row)
 	_, err := d.Exec(ctx, query, id)
 	return err
 }
@@ -82,10 +164,25 @@ func (d *DBMethods) EachPrepared(ctx context.Context, prep *Prepared, callback f
 }
 
 func (d *DBMethods) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.Exec(ctx, query, args...)
+	}
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	start := time.Now()
-	res, err := d.DB.ExecContext(ctx, d.fixQuery(query), args...)
-	d.log("Exec", start, err, false, d.fixQuery(query), args...)
-	return res, err
+	query, args, err = d.fixQuery(query, args)
+	if err != nil {
+		d.log(ctx, "Exec", start, err, false, query, args...)
+		return nil, queryError("Exec", query, len(args), start, err)
+	}
+	ctx, cancel := d.timeout(ctx)
+	defer cancel()
+	res, err := d.DB.ExecContext(ctx, query, args...)
+	d.log(ctx, "Exec", start, err, false, query, args...)
+	return res, queryError("Exec", query, len(args), start, err)
 }
 
 func (d *DBMethods) ExecPrepared(ctx context.Context, prep *Prepared) (sql.Result, error) {
@@ -93,23 +190,76 @@ func (d *DBMethods) ExecPrepared(ctx context.Context, prep *Prepared) (sql.Resul
 }
 
 func (d *DBMethods) InsertRow(ctx context.Context, row any) error {
-	query, args := insertRowString(row)
+	query, args := insertRowString(d.dialect(), row)
 	_, err := d.Exec(ctx, query, args...)
 	return err
 }
 
+func (d *DBMethods) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	q, args, err := namedQuery(d.dialect(), query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return d.Exec(ctx, q, args...)
+}
+
+func (d *DBMethods) NamedQuery(ctx context.Context, query string, arg any) (*Rows, error) {
+	q, args, err := namedQuery(d.dialect(), query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return d.Query(ctx, q, args...)
+}
+
+func (d *DBMethods) NamedQueryRow(ctx context.Context, query string, arg any) *Row {
+	q, args, err := namedQuery(d.dialect(), query, arg)
+	if err != nil {
+		return &Row{err: err}
+	}
+	return d.QueryRow(ctx, q, args...)
+}
+
 func (d *DBMethods) Ping(ctx context.Context) error {
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.Ping(ctx)
+	}
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
 	start := time.Now()
-	err := d.DB.PingContext(ctx)
-	d.log("Ping", start, err, false, "")
+	err = d.DB.PingContext(ctx)
+	d.log(ctx, "Ping", start, err, false, "")
 	return err
 }
 
-func (d *DBMethods) Prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+// Prepare creates prepared statement, it can be used in transaction by
+// Tx.Stmt, statement of transaction is created when ctx has transaction
+func (d *DBMethods) Prepare(ctx context.Context, query string) (*Stmt, error) {
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.Prepare(ctx, query)
+	}
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 	start := time.Now()
-	stm, err := d.DB.PrepareContext(ctx, d.fixQuery(query))
-	d.log("Prepare", start, err, false, d.fixQuery(query))
-	return stm, err
+	fixed, err := fixPrepareQuery(d.dialect(), query)
+	var stmt *sql.Stmt
+	if err == nil {
+		stmt, err = d.DB.PrepareContext(ctx, fixed)
+	}
+	d.log(ctx, "Prepare", start, err, false, fixed)
+	if err != nil {
+		return nil, queryError("Prepare", fixed, 0, start, err)
+	}
+	return &Stmt{db: d, query: query, stmt: stmt}, nil
+}
+
+func (d *DBMethods) Paginate(ctx context.Context, query string, page Page, callback func(ctx context.Context, rows *Rows) error, args ...any) (*PageResult, error) {
+	return paginate(ctx, d, d.dialect(), query, page, callback, args...)
 }
 
 func (d *DBMethods) PrepareSQL(query string, args ...any) *Prepared {
@@ -117,10 +267,31 @@ func (d *DBMethods) PrepareSQL(query string, args ...any) *Prepared {
 }
 
 func (d *DBMethods) Query(ctx context.Context, query string, args ...any) (*Rows, error) {
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.Query(ctx, query, args...)
+	}
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return &Rows{}, err
+	}
 	start := time.Now()
-	rows, err := d.DB.QueryContext(ctx, d.fixQuery(query), args...)
-	d.log("Query", start, err, false, d.fixQuery(query), args...)
-	return &Rows{Rows: rows}, err
+	query, args, err = d.fixQuery(query, args)
+	if err != nil {
+		release()
+		d.log(ctx, "Query", start, err, false, query, args...)
+		return &Rows{}, queryError("Query", query, len(args), start, err)
+	}
+	ctx, cancel := d.timeout(ctx)
+	rows, err := d.DB.QueryContext(ctx, query, args...)
+	d.log(ctx, "Query", start, err, false, query, args...)
+	done := func() {
+		cancel()
+		release()
+	}
+	if err != nil {
+		done()
+	}
+	return &Rows{Rows: rows, cancel: done}, queryError("Query", query, len(args), start, err)
 }
 
 func (d *DBMethods) QueryPrepared(ctx context.Context, prep *Prepared) (*Rows, error) {
@@ -128,14 +299,40 @@ func (d *DBMethods) QueryPrepared(ctx context.Context, prep *Prepared) (*Rows, e
 }
 
 func (d *DBMethods) QueryRow(ctx context.Context, query string, args ...any) *Row {
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.QueryRow(ctx, query, args...)
+	}
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return &Row{err: err}
+	}
 	start := time.Now()
-	row := d.DB.QueryRowContext(ctx, d.fixQuery(query), args...)
-	d.log("QueryRow", start, nil, false, d.fixQuery(query), args...)
-	return &Row{Row: row}
+	query, args, err = d.fixQuery(query, args)
+	if err != nil {
+		release()
+		d.log(ctx, "QueryRow", start, err, false, query, args...)
+		return &Row{err: queryError("QueryRow", query, len(args), start, err)}
+	}
+	ctx, cancel := d.timeout(ctx)
+	row := d.DB.QueryRowContext(ctx, query, args...)
+	if d.Debug {
+		log(d.logger(), "QueryRow", start, nil, false, query, args...)
+	}
+	return &Row{
+		Row:  row,
+		hook: rowHooks(ctx, d.Hooks, "QueryRow", start, false, query, args...),
+		cancel: func() {
+			cancel()
+			release()
+		},
+		wrap: func(err error) error {
+			return queryError("QueryRow", query, len(args), start, err)
+		},
+	}
 }
 
 func (d *DBMethods) QueryRowByID(ctx context.Context, id int64, row any) error {
-	query := queryRowByIDString(row)
+	query := queryRowByIDString(d.dialect(), row)
 	return d.QueryRow(ctx, query, id).Scans(row)
 }
 
@@ -145,7 +342,7 @@ func (d *DBMethods) QueryRowPrepared(ctx context.Context, prep *Prepared) *Row {
 
 func (d *DBMethods) RowExists(ctx context.Context, id int64, row any) bool {
 	var exists int
-	query := rowExistsString(row)
+	query := rowExistsString(d.dialect(), row)
 	if err := d.QueryRow(ctx, query, id).Scan(&exists); err == nil && exists == 1 {
 		return true
 	}
@@ -155,32 +352,73 @@ func (d *DBMethods) RowExists(ctx context.Context, id int64, row any) bool {
 func (d *DBMethods) SetConnMaxLifetime(t time.Duration) {
 	start := time.Now()
 	d.DB.SetConnMaxLifetime(t)
-	d.log("SetConnMaxLifetime", start, nil, false, "")
+	d.log(context.Background(), "SetConnMaxLifetime", start, nil, false, "")
 }
 
 func (d *DBMethods) SetMaxIdleConns(n int) {
 	start := time.Now()
 	d.DB.SetMaxIdleConns(n)
-	d.log("SetMaxIdleConns", start, nil, false, "")
+	d.log(context.Background(), "SetMaxIdleConns", start, nil, false, "")
 }
 
 func (d *DBMethods) SetMaxOpenConns(n int) {
 	start := time.Now()
 	d.DB.SetMaxOpenConns(n)
-	d.log("SetMaxOpenConns", start, nil, false, "")
+	d.log(context.Background(), "SetMaxOpenConns", start, nil, false, "")
 }
 
+// Shutdown rejects new calls with ErrShutdown, waits for active queries,
+// rows and transactions until ctx is done and closes connections pool
+func (d *DBMethods) Shutdown(ctx context.Context) error {
+	err := d.inflight.drain(ctx)
+	return errors.Join(err, d.Close())
+}
+
+// Stats returns connections pool statistics
+func (d *DBMethods) Stats() sql.DBStats {
+	return d.DB.Stats()
+}
+
+// Transaction runs callback inside transaction and passes it context with
+// the transaction, so engine methods called with that context join it, when
+// ctx already has transaction of engine, callback runs inside its savepoint
 func (d *DBMethods) Transaction(ctx context.Context, callback func(ctx context.Context, tx *Tx) error) error {
+	return d.TransactionWith(ctx, nil, callback)
+}
+
+// TransactionWith works like Transaction, but starts transaction with
+// options, for example isolation level or read-only mode. Transaction is
+// rolled back when callback fails or panics or when ctx is done before commit
+func (d *DBMethods) TransactionWith(ctx context.Context, opts *sql.TxOptions, callback func(ctx context.Context, tx *Tx) error) error {
 	if callback == nil {
 		return fmt.Errorf("callback is not set")
 	}
-	tx, err := d.Begin(ctx, nil)
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.Transaction(ctx, callback)
+	}
+	tx, err := d.Begin(ctx, opts)
 	if err != nil {
 		return err
 	}
-	if err := callback(ctx, tx); err != nil {
-		rerr := tx.Rollback()
-		if rerr != nil {
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			err := fmt.Errorf("panic: %v", p)
+			if rerr := tx.rollback(err); rerr != nil {
+				err = fmt.Errorf("%w, rollback failed: %v", err, rerr)
+			}
+			d.log(ctx, "Transaction", start, err, true, "")
+			panic(p)
+		}
+	}()
+	err = callback(ContextWithTx(ctx, tx), tx)
+	if err == nil {
+		// Changes are not committed when ctx is cancelled during callback
+		err = ctx.Err()
+	}
+	if err != nil {
+		rerr := tx.rollback(err)
+		if rerr != nil && !errors.Is(rerr, sql.ErrTxDone) {
 			return fmt.Errorf(
 				"%v: %v",
 				rerr,
@@ -192,14 +430,27 @@ func (d *DBMethods) Transaction(ctx context.Context, callback func(ctx context.C
 	return tx.Commit()
 }
 
+// TransactionRetry works like Transaction, but repeats it with new Tx when it
+// fails with retryable error, so callback must be safe to run several times,
+// inside transaction of ctx it runs once in savepoint and outer transaction
+// should be retried instead
+func (d *DBMethods) TransactionRetry(ctx context.Context, policy RetryPolicy, callback func(ctx context.Context, tx *Tx) error) error {
+	if tx := d.ContextTx(ctx); tx != nil {
+		return tx.Transaction(ctx, callback)
+	}
+	return transactionRetry(ctx, d.dialect(), policy, func() error {
+		return d.Transaction(ctx, callback)
+	})
+}
+
 func (d *DBMethods) UpdateRow(ctx context.Context, row any) error {
-	query, args := updateRowString(row)
+	query, args := updateRowString(d.dialect(), row)
 	_, err := d.Exec(ctx, query, args...)
 	return err
 }
 
 func (d *DBMethods) UpdateRowOnly(ctx context.Context, row any, fields ...string) error {
-	query, args := updateRowString(row, fields...)
+	query, args := updateRowString(d.dialect(), row, fields...)
 	_, err := d.Exec(ctx, query, args...)
 	return err
 }
@@ -0,0 +1,238 @@
+package common
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+type PlaceholderStyle int
+
+const (
+	// PlaceholderDollar is PostgreSQL style: $1, $2, $3
+	PlaceholderDollar PlaceholderStyle = iota
+
+	// PlaceholderQuestion is MySQL style: ?, ?, ?
+	PlaceholderQuestion
+
+	// PlaceholderNumbered is SQLite style: ?1, ?2, ?3
+	PlaceholderNumbered
+)
+
+// TxMode describes how transaction with options is started by engine
+type TxMode struct {
+	// Options are passed to driver
+	Options *sql.TxOptions
+
+	// Start query starts transaction on dedicated connection instead of
+	// driver, transaction is finished by COMMIT or ROLLBACK
+	Start string
+
+	// Begin queries are executed right after transaction is started
+	Begin []string
+
+	// Reset queries are executed on dedicated connection after transaction
+	// is finished, connection is discarded when they fail
+	Reset []string
+}
+
+// LockMode describes how named locks are taken by engine, queries accept
+// lock key as $1 and return true when lock is taken or released, when Lock
+// is empty table with lease expiry is used instead of session locks
+type LockMode struct {
+	// Key converts lock name to query argument, name is used when it's nil
+	Key func(name string) any
+
+	// Lock waits for lock
+	Lock string
+
+	// TryLock takes lock when it's free
+	TryLock string
+
+	// Unlock releases lock
+	Unlock string
+}
+
+// Dialect describes SQL syntax differences between database engines
+type Dialect interface {
+	// LimitOffset returns LIMIT/OFFSET clause, limit less or equal zero
+	// means no limit
+	LimitOffset(limit, offset int64) string
+
+	// Name returns dialect name, the same as URL scheme of engine
+	Name() string
+
+	// Placeholder returns bind params style of engine
+	Placeholder() PlaceholderStyle
+
+	// QuoteIdent quotes table or column name
+	QuoteIdent(name string) string
+}
+
+// LockDialect is optional interface of Dialect, lock table is used for
+// dialects which don't implement it
+type LockDialect interface {
+	// LockMode returns queries of session-scoped named locks
+	LockMode() LockMode
+}
+
+// RetryDialect is optional interface of Dialect, errors of dialects which
+// don't implement it are not retried by default
+type RetryDialect interface {
+	// Retryable reports whether transaction which failed with err can be
+	// retried, for example on serialization failure or deadlock
+	Retryable(err error) bool
+}
+
+// SyntaxDialect is optional interface of Dialect, standard SQL is used for
+// dialects which don't implement it
+type SyntaxDialect interface {
+	// Bool returns boolean literal
+	Bool(value bool) string
+
+	// CurrentTimestamp returns SQL expression of current date and time
+	CurrentTimestamp() string
+
+	// Returning reports whether INSERT/UPDATE/DELETE ... RETURNING is supported
+	Returning() bool
+
+	// Upsert returns clause which is appended to INSERT statement for
+	// updating columns when row with same conflict columns already exists
+	Upsert(conflict []string, update []string) string
+}
+
+// TxDialect is optional interface of Dialect, transaction options of
+// dialects which don't implement it are passed to driver as is
+type TxDialect interface {
+	// TxMode maps transaction options to engine, error is returned when
+	// isolation level is not supported
+	TxMode(opts *sql.TxOptions) (TxMode, error)
+}
+
+// Bool returns boolean literal of dialect
+func Bool(dialect Dialect, value bool) string {
+	return syntax(dialect).Bool(value)
+}
+
+// CurrentTimestamp returns SQL expression of current date and time of dialect
+func CurrentTimestamp(dialect Dialect) string {
+	return syntax(dialect).CurrentTimestamp()
+}
+
+// OnConflict returns standard ON CONFLICT clause, it's DO NOTHING when
+// update is empty
+func OnConflict(dialect Dialect, conflict []string, update []string) string {
+	var clause = "ON CONFLICT"
+	if len(conflict) > 0 {
+		columns := make([]string, len(conflict))
+		for i, column := range conflict {
+			columns[i] = dialect.QuoteIdent(column)
+		}
+		clause += " (" + strings.Join(columns, ", ") + ")"
+	}
+	if len(update) == 0 {
+		return clause + " DO NOTHING"
+	}
+	values := make([]string, len(update))
+	for i, column := range update {
+		values[i] = dialect.QuoteIdent(column) + " = excluded." + dialect.QuoteIdent(column)
+	}
+	return clause + " DO UPDATE SET " + strings.Join(values, ", ")
+}
+
+// Returning reports whether dialect supports INSERT/UPDATE/DELETE ... RETURNING
+func Returning(dialect Dialect) bool {
+	return syntax(dialect).Returning()
+}
+
+// Upsert returns clause of dialect which is appended to INSERT statement for
+// updating columns when row with same conflict columns already exists
+func Upsert(dialect Dialect, conflict []string, update []string) string {
+	return syntax(dialect).Upsert(conflict, update)
+}
+
+func lockMode(dialect Dialect) LockMode {
+	if d, ok := dialect.(LockDialect); ok {
+		return d.LockMode()
+	}
+	return LockMode{}
+}
+
+func retryable(dialect Dialect) func(err error) bool {
+	if d, ok := dialect.(RetryDialect); ok {
+		return d.Retryable
+	}
+	return func(err error) bool { return false }
+}
+
+func syntax(dialect Dialect) SyntaxDialect {
+	if d, ok := dialect.(SyntaxDialect); ok {
+		return d
+	}
+	return plainDialect{name: dialect.Name(), quote: dialect.QuoteIdent}
+}
+
+func txMode(dialect Dialect, opts *sql.TxOptions) (TxMode, error) {
+	if d, ok := dialect.(TxDialect); ok {
+		return d.TxMode(opts)
+	}
+	return TxMode{Options: opts}, nil
+}
+
+// plainDialect is used when dialect of driver is unknown, queries are sent to
+// driver as is, it's also standard SQL syntax of other dialects
+type plainDialect struct {
+	name string
+
+	// quote is QuoteIdent of dialect which syntax is provided
+	quote func(name string) string
+}
+
+func (plainDialect) Bool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func (plainDialect) CurrentTimestamp() string {
+	return "CURRENT_TIMESTAMP"
+}
+
+func (plainDialect) LimitOffset(limit, offset int64) string {
+	var clauses []string
+	if limit > 0 {
+		clauses = append(clauses, "LIMIT "+strconv.FormatInt(limit, 10))
+	}
+	if offset > 0 {
+		clauses = append(clauses, "OFFSET "+strconv.FormatInt(offset, 10))
+	}
+	return strings.Join(clauses, " ")
+}
+
+func (d plainDialect) Name() string {
+	return d.name
+}
+
+func (plainDialect) Placeholder() PlaceholderStyle {
+	return PlaceholderDollar
+}
+
+func (d plainDialect) QuoteIdent(name string) string {
+	if d.quote != nil {
+		return d.quote(name)
+	}
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = `"` + strings.ReplaceAll(part, `"`, `""`) + `"`
+	}
+	return strings.Join(parts, ".")
+}
+
+func (plainDialect) Returning() bool {
+	return false
+}
+
+func (d plainDialect) Upsert(conflict []string, update []string) string {
+	return OnConflict(d, conflict, update)
+}
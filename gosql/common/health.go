@@ -0,0 +1,175 @@
+package common
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type HealthState int
+
+const (
+	// HealthUnknown is state before first check
+	HealthUnknown HealthState = iota
+
+	// Healthy means last check succeeded
+	Healthy
+
+	// Unhealthy means FailureThreshold checks in a row failed
+	Unhealthy
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case Healthy:
+		return "healthy"
+	case Unhealthy:
+		return "unhealthy"
+	default:
+		return "unknown"
+	}
+}
+
+// Health is result of health checks
+type Health struct {
+	State HealthState
+
+	// ConsecutiveFailures is count of failed checks in a row
+	ConsecutiveFailures int
+
+	// LastCheck is time of last check
+	LastCheck time.Time
+
+	// LastError is error of last check, nil when it succeeded
+	LastError error
+
+	// Latency is duration of last check
+	Latency time.Duration
+}
+
+type HealthConfig struct {
+	// Interval between checks, 10s by default
+	Interval time.Duration
+
+	// Timeout of each check, Interval by default
+	Timeout time.Duration
+
+	// FailureThreshold is count of failed checks in a row after which state
+	// becomes Unhealthy, 1 by default
+	FailureThreshold int
+
+	// OnChange is called when state is changed, calls are serialized in order
+	// of checks, so it must not block for long and must not call Check
+	OnChange func(prev, health Health)
+}
+
+// Pinger is database checked by HealthMonitor, Engine implements it
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// HealthMonitor pings database in background and keeps its health state
+type HealthMonitor struct {
+	cfg HealthConfig
+	db  Pinger
+
+	// notify serializes state updates with OnChange calls
+	notify sync.Mutex
+	mu     sync.RWMutex
+	health Health
+
+	start sync.Once
+	stop  sync.Once
+	done  chan struct{}
+	wg    sync.WaitGroup
+}
+
+func NewHealthMonitor(db Pinger, cfg HealthConfig) *HealthMonitor {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = cfg.Interval
+	}
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 1
+	}
+	return &HealthMonitor{
+		cfg:  cfg,
+		db:   db,
+		done: make(chan struct{}),
+	}
+}
+
+// Check pings database at once and updates health
+func (m *HealthMonitor) Check(ctx context.Context) Health {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := m.db.Ping(ctx)
+	latency := time.Since(start)
+
+	m.notify.Lock()
+	defer m.notify.Unlock()
+
+	m.mu.Lock()
+	prev := m.health
+	health := Health{
+		State:     prev.State,
+		LastCheck: start,
+		LastError: err,
+		Latency:   latency,
+	}
+	if err == nil {
+		health.State = Healthy
+	} else {
+		health.ConsecutiveFailures = prev.ConsecutiveFailures + 1
+		if health.ConsecutiveFailures >= m.cfg.FailureThreshold {
+			health.State = Unhealthy
+		}
+	}
+	m.health = health
+	m.mu.Unlock()
+
+	if health.State != prev.State && m.cfg.OnChange != nil {
+		m.cfg.OnChange(prev, health)
+	}
+
+	return health
+}
+
+// Health returns result of last check
+func (m *HealthMonitor) Health() Health {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.health
+}
+
+// Start runs checks in background, first check is done at once
+func (m *HealthMonitor) Start() {
+	m.start.Do(func() {
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			ticker := time.NewTicker(m.cfg.Interval)
+			defer ticker.Stop()
+			for {
+				m.Check(context.Background())
+				select {
+				case <-m.done:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	})
+}
+
+// Stop stops background checks and waits for running check
+func (m *HealthMonitor) Stop() {
+	m.stop.Do(func() {
+		close(m.done)
+	})
+	m.wg.Wait()
+}
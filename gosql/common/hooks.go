@@ -0,0 +1,43 @@
+package common
+
+import (
+	"context"
+	"time"
+)
+
+// QueryEvent describes finished call of engine or transaction method
+type QueryEvent struct {
+	Args     []any
+	Duration time.Duration
+	Err      error
+	Func     string
+	Query    string
+	Tx       bool
+}
+
+// Hook is called after each logged call of engine or transaction method
+type Hook func(ctx context.Context, event QueryEvent)
+
+// rowHooks returns func which runs hooks when result of QueryRow is known
+func rowHooks(ctx context.Context, hooks []Hook, fname string, start time.Time, tx bool, query string, args ...any) func(err error) {
+	return func(err error) {
+		runHooks(ctx, hooks, fname, start, err, tx, query, args...)
+	}
+}
+
+func runHooks(ctx context.Context, hooks []Hook, fname string, start time.Time, err error, tx bool, query string, args ...any) {
+	if len(hooks) == 0 {
+		return
+	}
+	event := QueryEvent{
+		Args:     args,
+		Duration: time.Since(start),
+		Err:      err,
+		Func:     fname,
+		Query:    query,
+		Tx:       tx,
+	}
+	for _, hook := range hooks {
+		hook(ctx, event)
+	}
+}
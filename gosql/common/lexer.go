@@ -0,0 +1,179 @@
+package common
+
+import (
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenText tokenKind = iota
+	tokenString
+	tokenQuotedIdent
+	tokenComment
+	tokenDollarQuoted
+	tokenPositional
+	tokenNamed
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || isDigit(c)
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// tokenize splits SQL query to tokens, so placeholders can be found without
+// touching string literals, quoted identifiers, comments and PostgreSQL
+// dollar-quoted bodies. Backslash escapes are recognised in PostgreSQL
+// escape strings like E'\n' and, when mysql is set, in all strings, mysql also
+// enables # comments
+func tokenize(query string, mysql bool) []token {
+	tokens := []token{}
+	text := 0
+
+	emit := func(kind tokenKind, start, end int) {
+		if text < start {
+			tokens = append(tokens, token{tokenText, query[text:start]})
+		}
+		tokens = append(tokens, token{kind, query[start:end]})
+		text = end
+	}
+
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			backslash := (mysql && c != '`') || (c == '\'' && isEscapePrefix(query, i))
+			end := scanQuoted(query, i, c, backslash)
+			if c == '\'' {
+				emit(tokenString, i, end)
+			} else {
+				emit(tokenQuotedIdent, i, end)
+			}
+			i = end
+		case c == '-' && strings.HasPrefix(query[i:], "--"), c == '#' && mysql:
+			end := scanLineComment(query, i)
+			emit(tokenComment, i, end)
+			i = end
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := scanBlockComment(query, i)
+			emit(tokenComment, i, end)
+			i = end
+		case c == '$' && i > 0 && isIdentChar(query[i-1]):
+			// Part of identifier like col$1
+			i++
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			end := i + 1
+			for end < len(query) && isDigit(query[end]) {
+				end++
+			}
+			emit(tokenPositional, i, end)
+			i = end
+		case c == '$':
+			if end, ok := scanDollarQuoted(query, i); ok {
+				emit(tokenDollarQuoted, i, end)
+				i = end
+			} else {
+				i++
+			}
+		case c == ':' && i+1 < len(query) && query[i+1] == ':':
+			// PostgreSQL type cast
+			i += 2
+		case c == ':' && i+1 < len(query) && isIdentStart(query[i+1]):
+			end := i + 1
+			for end < len(query) && isIdentChar(query[end]) {
+				end++
+			}
+			emit(tokenNamed, i, end)
+			i = end
+		default:
+			i++
+		}
+	}
+
+	if text < len(query) {
+		tokens = append(tokens, token{tokenText, query[text:]})
+	}
+
+	return tokens
+}
+
+// isEscapePrefix reports whether string literal at start is PostgreSQL
+// escape string like E'it\'s'
+func isEscapePrefix(query string, start int) bool {
+	if start == 0 || (query[start-1] != 'E' && query[start-1] != 'e') {
+		return false
+	}
+	return start == 1 || !isIdentChar(query[start-2])
+}
+
+func scanBlockComment(query string, start int) int {
+	depth := 0
+	for i := start; i < len(query)-1; i++ {
+		switch {
+		case query[i] == '/' && query[i+1] == '*':
+			depth++
+			i++
+		case query[i] == '*' && query[i+1] == '/':
+			depth--
+			i++
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(query)
+}
+
+func scanDollarQuoted(query string, start int) (int, bool) {
+	end := start + 1
+	for end < len(query) && query[end] != '$' {
+		if !isIdentChar(query[end]) {
+			return 0, false
+		}
+		end++
+	}
+	if end >= len(query) {
+		return 0, false
+	}
+	tag := query[start : end+1]
+	if pos := strings.Index(query[end+1:], tag); pos >= 0 {
+		return end + 1 + pos + len(tag), true
+	}
+	return len(query), true
+}
+
+func scanLineComment(query string, start int) int {
+	if end := strings.IndexByte(query[start:], '\n'); end >= 0 {
+		return start + end + 1
+	}
+	return len(query)
+}
+
+func scanQuoted(query string, start int, quote byte, backslash bool) int {
+	for i := start + 1; i < len(query); i++ {
+		if backslash && query[i] == '\\' {
+			i++
+			continue
+		}
+		if query[i] == quote {
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
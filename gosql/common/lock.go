@@ -0,0 +1,310 @@
+package common
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLockLease is lease of lock table row, it's renewed while lock is held
+const DefaultLockLease = 30 * time.Second
+
+// lockPollInterval is interval of lock table checks by Lock
+const lockPollInterval = 100 * time.Millisecond
+
+// lock is held named lock
+type lock struct {
+	// conn is pinned connection of session lock
+	conn *sql.Conn
+
+	// owner is token of lock table row, stop and stopped finish its renewal
+	owner   string
+	stop    chan struct{}
+	stopped chan struct{}
+
+	// lost is closed when lock is released or its lease is lost
+	lost     chan struct{}
+	lostOnce sync.Once
+}
+
+func newLock(conn *sql.Conn, owner string) *lock {
+	lk := &lock{conn: conn, owner: owner, lost: make(chan struct{})}
+	if conn == nil {
+		lk.stop = make(chan struct{})
+		lk.stopped = make(chan struct{})
+	}
+	return lk
+}
+
+func (lk *lock) release() {
+	lk.lostOnce.Do(func() { close(lk.lost) })
+}
+
+type locks struct {
+	mu    sync.Mutex
+	held  map[string]*lock
+	table bool
+}
+
+func (l *locks) put(name string, lk *lock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.held == nil {
+		l.held = map[string]*lock{}
+	}
+	l.held[name] = lk
+}
+
+func (l *locks) get(name string) *lock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.held[name]
+}
+
+func (l *locks) take(name string) *lock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lk := l.held[name]
+	delete(l.held, name)
+	return lk
+}
+
+func (l *locks) takeAll() map[string]*lock {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	held := l.held
+	l.held = nil
+	return held
+}
+
+func (d *DBMethods) lease() time.Duration {
+	if d.LockLease > 0 {
+		return d.LockLease
+	}
+	return DefaultLockLease
+}
+
+// lockQuery runs lock query on pinned connection and returns its result
+func (d *DBMethods) lockQuery(ctx context.Context, fname string, conn *sql.Conn, query string, name string) (bool, error) {
+	mode := lockMode(d.dialect())
+	var key any = name
+	if mode.Key != nil {
+		key = mode.Key(name)
+	}
+	start := time.Now()
+	var ok sql.NullBool
+	query, args, err := d.fixQuery(query, []any{key})
+	if err == nil {
+		err = conn.QueryRowContext(ctx, query, args...).Scan(&ok)
+	}
+	d.log(ctx, fname, start, err, false, query, args...)
+	return ok.Valid && ok.Bool, queryError(fname, query, len(args), start, err)
+}
+
+// lockExec runs query of lock table outside of transaction of ctx and
+// reports whether rows are affected
+func (d *DBMethods) lockExec(ctx context.Context, fname string, query string, args ...any) (bool, error) {
+	start := time.Now()
+	query, args, err := d.fixQuery(query, args)
+	var n int64
+	if err == nil {
+		var res sql.Result
+		if res, err = d.DB.ExecContext(ctx, query, args...); err == nil {
+			n, err = res.RowsAffected()
+		}
+	}
+	d.log(ctx, fname, start, err, false, query, args...)
+	return n > 0, queryError(fname, query, len(args), start, err)
+}
+
+// lockSession takes session lock on new pinned connection
+func (d *DBMethods) lockSession(ctx context.Context, fname string, query string, name string) (bool, error) {
+	conn, err := d.DB.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	ok, err := d.lockQuery(ctx, fname, conn, query, name)
+	if err != nil || !ok {
+		conn.Close()
+		return false, err
+	}
+	d.locks.put(name, newLock(conn, ""))
+	return true, nil
+}
+
+// lockTable takes lock by inserting row of lock table or by replacing row
+// with expired lease
+func (d *DBMethods) lockTable(ctx context.Context, name string) (bool, error) {
+	if err := d.createLockTable(ctx); err != nil {
+		return false, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return false, err
+	}
+	owner := hex.EncodeToString(buf)
+	now := time.Now()
+	ok, err := d.lockExec(
+		ctx,
+		"TryLock",
+		`INSERT INTO gosql_locks (name, owner, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE gosql_locks.expires_at < $4`,
+		name, owner, now.Add(d.lease()).UnixMilli(), now.UnixMilli(),
+	)
+	if err != nil || !ok {
+		return false, err
+	}
+	lk := newLock(nil, owner)
+	go d.renewLock(name, lk)
+	d.locks.put(name, lk)
+	return true, nil
+}
+
+func (d *DBMethods) createLockTable(ctx context.Context) error {
+	d.locks.mu.Lock()
+	defer d.locks.mu.Unlock()
+	if d.locks.table {
+		return nil
+	}
+	_, err := d.lockExec(ctx, "Lock", `CREATE TABLE IF NOT EXISTS gosql_locks (
+		name VARCHAR(255) NOT NULL PRIMARY KEY,
+		owner VARCHAR(32) NOT NULL,
+		expires_at BIGINT NOT NULL
+	)`)
+	d.locks.table = err == nil
+	return err
+}
+
+// renewLock extends lease of lock table row until lock is released, lock is
+// lost when row is taken by other owner or lease is expired because of
+// renewal errors
+func (d *DBMethods) renewLock(name string, lk *lock) {
+	defer close(lk.stopped)
+	ticker := time.NewTicker(d.lease() / 3)
+	defer ticker.Stop()
+	renewed := time.Now()
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), d.lease()/3)
+			ok, err := d.lockExec(
+				ctx,
+				"RenewLock",
+				"UPDATE gosql_locks SET expires_at = $1 WHERE name = $2 AND owner = $3",
+				now.Add(d.lease()).UnixMilli(), name, lk.owner,
+			)
+			cancel()
+			if ok {
+				renewed = now
+			} else if err == nil || now.Sub(renewed) >= d.lease() {
+				lk.release()
+				return
+			}
+		}
+	}
+}
+
+// unlock releases lock taken by Lock or TryLock
+func (d *DBMethods) unlock(ctx context.Context, name string, lk *lock) error {
+	defer lk.release()
+	if lk.conn != nil {
+		defer lk.conn.Close()
+		ok, err := d.lockQuery(ctx, "Unlock", lk.conn, lockMode(d.dialect()).Unlock, name)
+		if err == nil && !ok {
+			err = fmt.Errorf("lock is not held: %s", name)
+		}
+		return err
+	}
+	close(lk.stop)
+	<-lk.stopped
+	ok, err := d.lockExec(ctx, "Unlock", "DELETE FROM gosql_locks WHERE name = $1 AND owner = $2", name, lk.owner)
+	if err == nil && !ok {
+		err = fmt.Errorf("lock lease is expired: %s", name)
+	}
+	return err
+}
+
+// unlockAll releases held locks before connections pool is closed
+func (d *DBMethods) unlockAll() {
+	for name, lk := range d.locks.takeAll() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = d.unlock(ctx, name, lk)
+		cancel()
+	}
+}
+
+// Lock waits for named lock which is held until Unlock or Close, lock is
+// shared by all engines of database, so it can be used for coordination of
+// several instances of service. Lock doesn't join transaction of ctx, it's
+// taken on its own connection and is not released by rollback
+func (d *DBMethods) Lock(ctx context.Context, name string) error {
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+	mode := lockMode(d.dialect())
+	if mode.Lock != "" {
+		ok, err := d.lockSession(ctx, "Lock", mode.Lock, name)
+		if err == nil && !ok {
+			err = fmt.Errorf("lock is not taken: %s", name)
+		}
+		return err
+	}
+	ticker := time.NewTicker(lockPollInterval)
+	defer ticker.Stop()
+	for {
+		ok, err := d.lockTable(ctx, name)
+		if err != nil || ok {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// LockLost returns channel which is closed when named lock is released or
+// lease of lock table row is lost, so holder can stop its work, channel of
+// not held lock is closed
+func (d *DBMethods) LockLost(ctx context.Context, name string) <-chan struct{} {
+	if lk := d.locks.get(name); lk != nil {
+		return lk.lost
+	}
+	lost := make(chan struct{})
+	close(lost)
+	return lost
+}
+
+// TryLock takes named lock when it's free and reports whether it's taken
+func (d *DBMethods) TryLock(ctx context.Context, name string) (bool, error) {
+	release, err := d.inflight.acquire()
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	mode := lockMode(d.dialect())
+	if mode.TryLock != "" {
+		return d.lockSession(ctx, "TryLock", mode.TryLock, name)
+	}
+	return d.lockTable(ctx, name)
+}
+
+// Unlock releases named lock taken by Lock or TryLock
+func (d *DBMethods) Unlock(ctx context.Context, name string) error {
+	lk := d.locks.take(name)
+	if lk == nil {
+		return fmt.Errorf("lock is not held: %s", name)
+	}
+	return d.unlock(ctx, name, lk)
+}
@@ -0,0 +1,142 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// DefaultBuckets are upper bounds of query duration histogram in seconds
+var DefaultBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type funcMetrics struct {
+	counts []uint64
+	errors uint64
+	sum    float64
+	total  uint64
+}
+
+// Metrics collects per-function query counts and latency histograms from
+// hook events and renders them with pool stats in Prometheus text format
+type Metrics struct {
+	buckets []float64
+
+	mu    sync.Mutex
+	funcs map[string]*funcMetrics
+}
+
+// NewMetrics returns metrics with given histogram buckets in seconds,
+// DefaultBuckets are used when buckets are not set
+func NewMetrics(buckets ...float64) *Metrics {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64{}, buckets...)
+	sort.Float64s(buckets)
+	return &Metrics{
+		buckets: buckets,
+		funcs:   map[string]*funcMetrics{},
+	}
+}
+
+// Hook returns hook which must be added to engine for collecting metrics
+func (m *Metrics) Hook() Hook {
+	return func(ctx context.Context, event QueryEvent) {
+		m.observe(event)
+	}
+}
+
+func (m *Metrics) observe(event QueryEvent) {
+	seconds := event.Duration.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.funcs[event.Func]
+	if !ok {
+		f = &funcMetrics{counts: make([]uint64, len(m.buckets))}
+		m.funcs[event.Func] = f
+	}
+	f.total++
+	f.sum += seconds
+	if event.Err != nil {
+		f.errors++
+	}
+	for i, bucket := range m.buckets {
+		if seconds <= bucket {
+			f.counts[i]++
+		}
+	}
+}
+
+// Handler returns http.Handler which renders metrics and pool stats of db
+func (m *Metrics) Handler(db interface{ Stats() sql.DBStats }) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		if db != nil {
+			writeStats(w, db.Stats())
+		}
+		m.write(w)
+	})
+}
+
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.funcs))
+	for name := range m.funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "# HELP gosql_queries_total Count of engine and transaction calls.")
+	fmt.Fprintln(w, "# TYPE gosql_queries_total counter")
+	for _, name := range names {
+		f := m.funcs[name]
+		fmt.Fprintf(w, "gosql_queries_total{func=%q,status=\"ok\"} %d\n", name, f.total-f.errors)
+		fmt.Fprintf(w, "gosql_queries_total{func=%q,status=\"error\"} %d\n", name, f.errors)
+	}
+
+	fmt.Fprintln(w, "# HELP gosql_query_duration_seconds Duration of engine and transaction calls.")
+	fmt.Fprintln(w, "# TYPE gosql_query_duration_seconds histogram")
+	for _, name := range names {
+		f := m.funcs[name]
+		for i, bucket := range m.buckets {
+			le := strconv.FormatFloat(bucket, 'g', -1, 64)
+			fmt.Fprintf(w, "gosql_query_duration_seconds_bucket{func=%q,le=%q} %d\n", name, le, f.counts[i])
+		}
+		fmt.Fprintf(w, "gosql_query_duration_seconds_bucket{func=%q,le=\"+Inf\"} %d\n", name, f.total)
+		fmt.Fprintf(w, "gosql_query_duration_seconds_sum{func=%q} %s\n", name, strconv.FormatFloat(f.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "gosql_query_duration_seconds_count{func=%q} %d\n", name, f.total)
+	}
+}
+
+func writeStats(w io.Writer, stats sql.DBStats) {
+	metrics := []struct {
+		name  string
+		kind  string
+		help  string
+		value string
+	}{
+		{"gosql_pool_max_open_connections", "gauge", "Max count of open connections.", strconv.Itoa(stats.MaxOpenConnections)},
+		{"gosql_pool_open_connections", "gauge", "Count of open connections.", strconv.Itoa(stats.OpenConnections)},
+		{"gosql_pool_in_use_connections", "gauge", "Count of connections in use.", strconv.Itoa(stats.InUse)},
+		{"gosql_pool_idle_connections", "gauge", "Count of idle connections.", strconv.Itoa(stats.Idle)},
+		{"gosql_pool_wait_count_total", "counter", "Count of waits for connection.", strconv.FormatInt(stats.WaitCount, 10)},
+		{"gosql_pool_wait_duration_seconds_total", "counter", "Time spent on waiting for connection.", strconv.FormatFloat(stats.WaitDuration.Seconds(), 'g', -1, 64)},
+		{"gosql_pool_max_idle_closed_total", "counter", "Count of connections closed by SetMaxIdleConns.", strconv.FormatInt(stats.MaxIdleClosed, 10)},
+		{"gosql_pool_max_idle_time_closed_total", "counter", "Count of connections closed by SetConnMaxIdleTime.", strconv.FormatInt(stats.MaxIdleTimeClosed, 10)},
+		{"gosql_pool_max_lifetime_closed_total", "counter", "Count of connections closed by SetConnMaxLifetime.", strconv.FormatInt(stats.MaxLifetimeClosed, 10)},
+	}
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", metric.name, metric.help)
+		fmt.Fprintf(w, "# TYPE %s %s\n", metric.name, metric.kind)
+		fmt.Fprintf(w, "%s %s\n", metric.name, metric.value)
+	}
+}
@@ -0,0 +1,115 @@
+package common
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/amacneil/dbmate/pkg/dbmate"
+	"github.com/amacneil/dbmate/pkg/dbutil"
+)
+
+var rMigrationFile = regexp.MustCompile(`^\d.*\.sql$`)
+var rMigrationVersion = regexp.MustCompile(`^\d+`)
+var rMigrationUp = regexp.MustCompile(`(?m)^--\s*migrate:up(.*)$`)
+var rMigrationDown = regexp.MustCompile(`(?m)^--\s*migrate:down.*$`)
+
+// Migrate applies dbmate compatible migrations from fsys using already opened
+// connections pool, applied versions are stored in the same schema_migrations
+// table as dbmate does, so both ways can be mixed
+func Migrate(db *sql.DB, dialect Dialect, fsys fs.FS) error {
+	mate := dbmate.New(&url.URL{Scheme: dialect.Name()})
+	mate.Log = io.Discard
+
+	driver, err := mate.GetDriver()
+	if err != nil {
+		return fmt.Errorf("DB get driver error: %w", err)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return fmt.Errorf("DB migration error: %w", err)
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && rMigrationFile.MatchString(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+
+	if err := driver.CreateMigrationsTable(db); err != nil {
+		return fmt.Errorf("DB migration error: %w", err)
+	}
+
+	applied, err := driver.SelectMigrations(db, -1)
+	if err != nil {
+		return fmt.Errorf("DB migration error: %w", err)
+	}
+
+	for _, file := range files {
+		version := rMigrationVersion.FindString(file)
+		if applied[version] {
+			continue
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return fmt.Errorf("DB migration error: %w", err)
+		}
+
+		up, transaction, err := parseMigration(string(data))
+		if err != nil {
+			return fmt.Errorf("DB migration error: %s: %w", file, err)
+		}
+
+		apply := func(tx dbutil.Transaction) error {
+			if _, err := tx.Exec(up); err != nil {
+				return err
+			}
+			return driver.InsertMigration(tx, version)
+		}
+
+		if transaction {
+			tx, err := db.Begin()
+			if err != nil {
+				return fmt.Errorf("DB migration error: %s: %w", file, err)
+			}
+			if err := apply(tx); err != nil {
+				_ = tx.Rollback()
+				return fmt.Errorf("DB migration error: %s: %w", file, err)
+			}
+			if err := tx.Commit(); err != nil {
+				return fmt.Errorf("DB migration error: %s: %w", file, err)
+			}
+		} else if err := apply(db); err != nil {
+			return fmt.Errorf("DB migration error: %s: %w", file, err)
+		}
+	}
+
+	return nil
+}
+
+// parseMigration returns "migrate:up" block of migration and whether it must
+// be applied inside transaction
+func parseMigration(contents string) (string, bool, error) {
+	up := rMigrationUp.FindStringSubmatchIndex(contents)
+	if up == nil {
+		return "", false, fmt.Errorf("migrate:up block is not defined")
+	}
+
+	end := len(contents)
+	if down := rMigrationDown.FindStringIndex(contents); down != nil && down[0] > up[0] {
+		end = down[0]
+	}
+
+	transaction := !strings.Contains(contents[up[2]:up[3]], "transaction:false")
+
+	return contents[up[0]:end], transaction, nil
+}
@@ -0,0 +1,102 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type namedCompiled struct {
+	query string
+	names []string
+}
+
+type namedKey struct {
+	query string
+	mysql bool
+}
+
+var namedQueries = newLRU[namedKey, *namedCompiled](queryCacheSize)
+
+// compileNamed replaces named params with positional, query is parsed by
+// MySQL rules when mysql is set
+func compileNamed(query string, mysql bool) (string, []string) {
+	key := namedKey{query, mysql}
+	if c, ok := namedQueries.get(key); ok {
+		return c.query, c.names
+	}
+
+	var sb strings.Builder
+	names := []string{}
+	positions := map[string]int{}
+
+	for _, t := range tokenize(query, mysql) {
+		if t.kind != tokenNamed {
+			sb.WriteString(t.text)
+			continue
+		}
+		name := t.text[1:]
+		position, ok := positions[name]
+		if !ok {
+			names = append(names, name)
+			position = len(names)
+			positions[name] = position
+		}
+		sb.WriteString("$" + strconv.Itoa(position))
+	}
+
+	c := &namedCompiled{query: sb.String(), names: names}
+	namedQueries.put(key, c)
+	return c.query, c.names
+}
+
+func bindNamed(names []string, arg any) ([]any, error) {
+	v := reflect.ValueOf(arg)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, fmt.Errorf("named argument is nil")
+		}
+		v = v.Elem()
+	}
+
+	values := map[string]any{}
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("named argument map key must be a string")
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			values[iter.Key().String()] = iter.Value().Interface()
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if tag := t.Field(i).Tag.Get("field"); tag != "" {
+				values[tag] = v.Field(i).Interface()
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported named argument type: %T", arg)
+	}
+
+	args := make([]any, len(names))
+	for i, name := range names {
+		value, ok := values[name]
+		if !ok {
+			return nil, fmt.Errorf("named parameter is not set: %s", name)
+		}
+		args[i] = value
+	}
+	return args, nil
+}
+
+func namedQuery(dialect Dialect, query string, arg any) (string, []any, error) {
+	q, names := compileNamed(query, dialect.Placeholder() == PlaceholderQuestion)
+	args, err := bindNamed(names, arg)
+	if err != nil {
+		return "", nil, err
+	}
+	return q, args, nil
+}
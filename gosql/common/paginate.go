@@ -0,0 +1,206 @@
+package common
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Page describes which part of query result must be returned by Paginate.
+// Offset mode is used by default, keyset (seek) mode is used when KeyColumn
+// is set, in this case KeyColumn must be unique and Offset is ignored
+type Page struct {
+	// Limit is max rows count on page, zero means no limit
+	Limit int64
+
+	// Offset is count of rows to skip in offset mode
+	Offset int64
+
+	// Count enables total rows count calculation
+	Count bool
+
+	// KeyColumn is result column name for keyset mode
+	KeyColumn string
+
+	// Cursor is PageResult.NextCursor of previous page in keyset mode,
+	// empty cursor means first page
+	Cursor string
+
+	// Desc sorts rows by KeyColumn in descending order in keyset mode
+	Desc bool
+}
+
+type PageResult struct {
+	// HasMore reports whether next page exists
+	HasMore bool
+
+	// NextCursor is opaque token for next page in keyset mode
+	NextCursor string
+
+	// Total is total rows count, filled only when Page.Count is set
+	Total int64
+}
+
+type queryer interface {
+	Each(ctx context.Context, query string, callback func(ctx context.Context, rows *Rows) error, args ...any) error
+	QueryRow(ctx context.Context, query string, args ...any) *Row
+}
+
+func countQueryString(query string) string {
+	return `SELECT COUNT(*) FROM (` + trimQuery(query) + `) gosql_count`
+}
+
+func decodeCursor(cursor string) (any, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(data) < 2 || data[1] != ':' {
+		return nil, fmt.Errorf("invalid cursor")
+	}
+	value := string(data[2:])
+	switch data[0] {
+	case 'i':
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v, nil
+		}
+	case 'f':
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v, nil
+		}
+	case 's':
+		return value, nil
+	case 'b':
+		return []byte(value), nil
+	case 't':
+		if v, err := time.Parse(time.RFC3339Nano, value); err == nil {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid cursor")
+}
+
+func encodeCursor(value any) string {
+	var data string
+	switch v := value.(type) {
+	case int64:
+		data = "i:" + strconv.FormatInt(v, 10)
+	case float64:
+		data = "f:" + strconv.FormatFloat(v, 'g', -1, 64)
+	case string:
+		data = "s:" + v
+	case []byte:
+		data = "b:" + string(v)
+	case time.Time:
+		data = "t:" + v.Format(time.RFC3339Nano)
+	default:
+		data = "s:" + fmt.Sprintf("%v", v)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(data))
+}
+
+func pageQueryString(dialect Dialect, query string, page Page, args []any) (string, []any, error) {
+	limit := page.Limit
+	if limit > 0 {
+		// One extra row for checking that next page exists
+		limit++
+	}
+
+	if page.KeyColumn == "" {
+		return strings.TrimSpace(trimQuery(query) + " " + dialect.LimitOffset(limit, page.Offset)), args, nil
+	}
+
+	column := dialect.QuoteIdent(page.KeyColumn)
+	sql := `SELECT * FROM (` + trimQuery(query) + `) gosql_page`
+	if page.Cursor != "" {
+		value, err := decodeCursor(page.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		op := ">"
+		if page.Desc {
+			op = "<"
+		}
+		args = append(append([]any{}, args...), value)
+		sql += ` WHERE ` + column + ` ` + op + ` $` + strconv.Itoa(len(args))
+	}
+	if page.Desc {
+		sql += ` ORDER BY ` + column + ` DESC`
+	} else {
+		sql += ` ORDER BY ` + column + ` ASC`
+	}
+	return strings.TrimSpace(sql + " " + dialect.LimitOffset(limit, 0)), args, nil
+}
+
+func paginate(ctx context.Context, q queryer, dialect Dialect, query string, page Page, callback func(ctx context.Context, rows *Rows) error, args ...any) (*PageResult, error) {
+	if callback == nil {
+		return nil, fmt.Errorf("callback is not set")
+	}
+
+	res := &PageResult{}
+
+	if page.Count {
+		if err := q.QueryRow(ctx, countQueryString(query), args...).Scan(&res.Total); err != nil {
+			return nil, err
+		}
+	}
+
+	sql, args, err := pageQueryString(dialect, query, page, args)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int64
+	var index = -1
+	var last any
+	err = q.Each(ctx, sql, func(ctx context.Context, rows *Rows) error {
+		count++
+		if page.Limit > 0 && count > page.Limit {
+			res.HasMore = true
+			return nil
+		}
+		if err := callback(ctx, rows); err != nil {
+			return err
+		}
+		if page.KeyColumn == "" {
+			return nil
+		}
+		columns, err := rows.Columns()
+		if err != nil {
+			return err
+		}
+		if index < 0 {
+			for i, column := range columns {
+				if column == page.KeyColumn {
+					index = i
+					break
+				}
+			}
+			if index < 0 {
+				return fmt.Errorf("key column is not found: %s", page.KeyColumn)
+			}
+		}
+		values := make([]any, len(columns))
+		for i := range values {
+			values[i] = new(any)
+		}
+		if err := rows.Rows.Scan(values...); err != nil {
+			return err
+		}
+		last = *(values[index].(*any))
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.HasMore && page.KeyColumn != "" {
+		res.NextCursor = encodeCursor(last)
+	}
+
+	return res, nil
+}
+
+func trimQuery(query string) string {
+	return strings.TrimSpace(strings.TrimRight(strings.TrimSpace(query), ";"))
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	gosqlerrors "github.com/vladimirok5959/golang-sql/gosql/errors"
+)
+
+// QueryError is returned by Exec, Query and QueryRow when query fails, it
+// keeps query context and can be unwrapped to driver error
+type QueryError struct {
+	// Func is engine or transaction method name
+	Func string
+
+	// Query is final SQL query which is sent to driver
+	Query string
+
+	// Args is count of query arguments
+	Args int
+
+	// Duration is time spent on query
+	Duration time.Duration
+
+	// Code is driver error code, see gosql/errors.Code
+	Code string
+
+	// Err is underlying error
+	Err error
+}
+
+func (e *QueryError) Error() string {
+	query := strings.Trim(rLogSpacesAll.ReplaceAllString(e.Query, " "), " ")
+	return e.Func + ": " + e.Err.Error() + " (query: " + query + ")"
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// queryError wraps err into QueryError, sql.ErrNoRows is returned as is
+// because it's expected result rather than failure
+func queryError(fname, query string, args int, start time.Time, err error) error {
+	if err == nil || errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	var qerr *QueryError
+	if errors.As(err, &qerr) {
+		return err
+	}
+	return &QueryError{
+		Func:     fname,
+		Query:    query,
+		Args:     args,
+		Duration: time.Since(start),
+		Code:     gosqlerrors.Code(err),
+		Err:      err,
+	}
+}
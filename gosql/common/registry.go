@@ -0,0 +1,68 @@
+package common
+
+import (
+	"database/sql"
+	"net/url"
+	"sync"
+)
+
+// EngineFactory opens connections pool for database URL of registered scheme
+type EngineFactory func(dbURL *url.URL) (*sql.DB, error)
+
+type registeredEngine struct {
+	factory EngineFactory
+	dialect Dialect
+}
+
+var engines = map[string]registeredEngine{}
+var enginesMutex sync.RWMutex
+
+// LookupEngine returns factory and dialect of engine registered for scheme
+func LookupEngine(scheme string) (EngineFactory, Dialect, bool) {
+	enginesMutex.RLock()
+	defer enginesMutex.RUnlock()
+	e, ok := engines[scheme]
+	return e.factory, e.dialect, ok
+}
+
+// RegisterEngine makes engine available by URL scheme, already registered
+// scheme or built-in one will be replaced
+func RegisterEngine(scheme string, factory EngineFactory, dialect Dialect) {
+	if scheme == "" {
+		panic("gosql: RegisterEngine scheme is empty")
+	}
+	if factory == nil {
+		panic("gosql: RegisterEngine factory is nil")
+	}
+	if dialect == nil {
+		panic("gosql: RegisterEngine dialect is nil")
+	}
+	enginesMutex.Lock()
+	defer enginesMutex.Unlock()
+	engines[scheme] = registeredEngine{factory, dialect}
+}
+
+var dialects = map[string]Dialect{}
+
+// LookupDialect returns dialect of driver or URL scheme, dialects of
+// registered engines take precedence
+func LookupDialect(name string) (Dialect, bool) {
+	enginesMutex.RLock()
+	defer enginesMutex.RUnlock()
+	if e, ok := engines[name]; ok {
+		return e.dialect, true
+	}
+	d, ok := dialects[name]
+	return d, ok
+}
+
+// RegisterDialect makes dialect available for DBMethods which Driver is name
+// and Dialect is not set, built-in dialects are registered by engine package
+func RegisterDialect(name string, dialect Dialect) {
+	if dialect == nil {
+		panic("gosql: RegisterDialect dialect is nil")
+	}
+	enginesMutex.Lock()
+	defer enginesMutex.Unlock()
+	dialects[name] = dialect
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"context"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy describes how TransactionRetry repeats failed transactions
+type RetryPolicy struct {
+	// MaxAttempts is max count of callback calls, 3 by default
+	MaxAttempts int
+
+	// MinBackoff and MaxBackoff limit delay between attempts, delay is
+	// random (full jitter) and its upper bound is doubled for each attempt,
+	// 10ms and 1s by default
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Retryable reports whether error can be retried, RetryDialect of engine
+	// is used by default
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	limit := p.MinBackoff << (attempt - 1)
+	if limit <= 0 || limit > p.MaxBackoff {
+		limit = p.MaxBackoff
+	}
+	return p.MinBackoff + rand.N(limit-p.MinBackoff+1)
+}
+
+func (p RetryPolicy) withDefaults(dialect Dialect) RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 3
+	}
+	if p.MinBackoff <= 0 {
+		p.MinBackoff = 10 * time.Millisecond
+	}
+	if p.MaxBackoff < p.MinBackoff {
+		p.MaxBackoff = max(time.Second, p.MinBackoff)
+	}
+	if p.Retryable == nil {
+		p.Retryable = retryable(dialect)
+	}
+	return p
+}
+
+// transactionRetry calls transaction until it succeeds, fails with not
+// retryable error or attempts are over, each attempt gets fresh Tx
+func transactionRetry(ctx context.Context, dialect Dialect, policy RetryPolicy, transaction func() error) error {
+	policy = policy.withDefaults(dialect)
+	for attempt := 1; ; attempt++ {
+		err := transaction()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(policy.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
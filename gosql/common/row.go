@@ -1,13 +1,68 @@
 package common
 
 import (
+	"context"
 	"database/sql"
 )
 
 type Row struct {
 	*sql.Row
+
+	cancel context.CancelFunc
+	err    error
+	wrap   func(err error) error
+
+	// hook reports result of query by Err or Scan
+	hook func(err error)
+}
+
+// Err returns error of query, query is finished when it fails, otherwise
+// connection is held until Scan like for sql.Row
+func (r *Row) Err() error {
+	if r.err != nil {
+		r.done(r.err)
+		return r.err
+	}
+	if err := r.Row.Err(); err != nil {
+		r.done(err)
+		return r.wrapErr(err)
+	}
+	return nil
+}
+
+func (r *Row) Scan(dest ...any) error {
+	if r.err != nil {
+		r.done(r.err)
+		return r.err
+	}
+	err := r.Row.Scan(dest...)
+	r.done(err)
+	return r.wrapErr(err)
 }
 
 func (r *Row) Scans(row any) error {
-	return r.Row.Scan(scans(row)...)
+	return r.Scan(scans(row)...)
+}
+
+func (r *Row) done(err error) {
+	if hook := r.hook; hook != nil {
+		r.hook = nil
+		hook(err)
+	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+func (r *Row) wrapErr(err error) error {
+	if err != nil && r.wrap != nil {
+		return r.wrap(err)
+	}
+	return err
+}
+
+// ErrorRow returns row which Err and Scan return err, it's useful for engine
+// wrappers which fail before query is sent
+func ErrorRow(err error) *Row {
+	return &Row{err: err}
 }
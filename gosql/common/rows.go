@@ -1,11 +1,36 @@
 package common
 
 import (
+	"context"
 	"database/sql"
 )
 
 type Rows struct {
 	*sql.Rows
+
+	cancel context.CancelFunc
+}
+
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	return err
+}
+
+// Next works like sql.Rows.Next, query is finished when rows are closed
+// after last row, so Close is not required after reading all rows
+func (r *Rows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	// Columns fails for closed rows only, rows are not closed when they
+	// have next result set
+	if _, err := r.Rows.Columns(); err != nil && r.cancel != nil {
+		r.cancel()
+	}
+	return false
 }
 
 func (r *Rows) Scans(row any) error {
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrShutdown is returned by engine methods which are called after Shutdown
+var ErrShutdown = errors.New("gosql: engine is shut down")
+
+// inflight counts active queries, rows and transactions of engine
+type inflight struct {
+	mu      sync.Mutex
+	active  int
+	closing bool
+	drained chan struct{}
+}
+
+// acquire registers new call, returned func must be called when call is done
+func (f *inflight) acquire() (func(), error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closing {
+		return nil, ErrShutdown
+	}
+	f.active++
+	return sync.OnceFunc(f.release), nil
+}
+
+func (f *inflight) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.active--
+	if f.active == 0 && f.drained != nil {
+		close(f.drained)
+		f.drained = nil
+	}
+}
+
+// drain rejects new calls and waits for active ones
+func (f *inflight) drain(ctx context.Context) error {
+	f.mu.Lock()
+	f.closing = true
+	if f.active == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	if f.drained == nil {
+		f.drained = make(chan struct{})
+	}
+	drained := f.drained
+	f.mu.Unlock()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,135 @@
+package common
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Stmt is prepared statement of engine or transaction, it accepts query
+// args in the same order as query with $n placeholders and logs calls
+type Stmt struct {
+	db    *DBMethods
+	err   error
+	query string
+	stmt  *sql.Stmt
+	tx    bool
+}
+
+// ambient returns statement of transaction stored in ctx for statement of
+// engine or nil
+func (s *Stmt) ambient(ctx context.Context) *Stmt {
+	if s.tx || s.db == nil {
+		return nil
+	}
+	if tx := s.db.ContextTx(ctx); tx != nil {
+		return tx.Stmt(ctx, s)
+	}
+	return nil
+}
+
+func (s *Stmt) acquire() (func(), error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	if s.tx {
+		return func() {}, nil
+	}
+	return s.db.inflight.acquire()
+}
+
+// Close closes statement, statements of transaction are closed when it's
+// finished
+func (s *Stmt) Close() error {
+	if s.stmt == nil {
+		return nil
+	}
+	return s.stmt.Close()
+}
+
+func (s *Stmt) fixQuery(args []any) (string, []any, error) {
+	return fixQueryArgs(s.db.dialect(), s.query, args)
+}
+
+func (s *Stmt) Exec(ctx context.Context, args ...any) (sql.Result, error) {
+	if stmt := s.ambient(ctx); stmt != nil {
+		return stmt.Exec(ctx, args...)
+	}
+	release, err := s.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	query, args, err := s.fixQuery(args)
+	if err != nil {
+		s.db.log(ctx, "StmtExec", start, err, s.tx, query, args...)
+		return nil, queryError("StmtExec", query, len(args), start, err)
+	}
+	ctx, cancel := s.db.timeout(ctx)
+	defer cancel()
+	res, err := s.stmt.ExecContext(ctx, args...)
+	s.db.log(ctx, "StmtExec", start, err, s.tx, query, args...)
+	return res, queryError("StmtExec", query, len(args), start, err)
+}
+
+func (s *Stmt) Query(ctx context.Context, args ...any) (*Rows, error) {
+	if stmt := s.ambient(ctx); stmt != nil {
+		return stmt.Query(ctx, args...)
+	}
+	release, err := s.acquire()
+	if err != nil {
+		return &Rows{}, err
+	}
+	start := time.Now()
+	query, args, err := s.fixQuery(args)
+	if err != nil {
+		release()
+		s.db.log(ctx, "StmtQuery", start, err, s.tx, query, args...)
+		return &Rows{}, queryError("StmtQuery", query, len(args), start, err)
+	}
+	ctx, cancel := s.db.timeout(ctx)
+	rows, err := s.stmt.QueryContext(ctx, args...)
+	s.db.log(ctx, "StmtQuery", start, err, s.tx, query, args...)
+	done := func() {
+		cancel()
+		release()
+	}
+	if err != nil {
+		done()
+	}
+	return &Rows{Rows: rows, cancel: done}, queryError("StmtQuery", query, len(args), start, err)
+}
+
+func (s *Stmt) QueryRow(ctx context.Context, args ...any) *Row {
+	if stmt := s.ambient(ctx); stmt != nil {
+		return stmt.QueryRow(ctx, args...)
+	}
+	release, err := s.acquire()
+	if err != nil {
+		return &Row{err: err}
+	}
+	start := time.Now()
+	query, args, err := s.fixQuery(args)
+	if err != nil {
+		release()
+		s.db.log(ctx, "StmtQueryRow", start, err, s.tx, query, args...)
+		return &Row{err: queryError("StmtQueryRow", query, len(args), start, err)}
+	}
+	ctx, cancel := s.db.timeout(ctx)
+	row := s.stmt.QueryRowContext(ctx, args...)
+	if s.db.Debug {
+		log(s.db.logger(), "StmtQueryRow", start, nil, s.tx, query, args...)
+	}
+	return &Row{
+		Row:  row,
+		hook: rowHooks(ctx, s.db.Hooks, "StmtQueryRow", start, s.tx, query, args...),
+		cancel: func() {
+			cancel()
+			release()
+		},
+		wrap: func(err error) error {
+			return queryError("StmtQueryRow", query, len(args), start, err)
+		},
+	}
+}
@@ -3,36 +3,156 @@ package common
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"os"
-
+	"slices"
+	"sync"
 	"time"
 )
 
+// txConn runs queries of transaction
+type txConn interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
 type Tx struct {
+	db *DBMethods
 	tx *sql.Tx
 
-	Debug  bool
-	Driver string
-	start  time.Time
+	// conn is dedicated connection of transaction started by TxMode.Start,
+	// its statements are closed and reset queries are executed on it when
+	// transaction is finished
+	conn  *sql.Conn
+	reset []string
+	stmts []*sql.Stmt
+
+	// cache holds statements of transaction by statements of engine, so
+	// they are not prepared again on each call
+	cache   map[*Stmt]*Stmt
+	cacheMu sync.Mutex
+
+	Debug   bool
+	Dialect Dialect
+	Driver  string
+	release func()
+
+	savepoints int
+
+	// ctx is passed to OnCommit and OnRollback callbacks
+	ctx        context.Context
+	onCommit   []func(ctx context.Context)
+	onRollback []func(ctx context.Context, err error)
+}
+
+// afterCommit calls OnCommit callbacks once
+func (t *Tx) afterCommit() {
+	callbacks := t.onCommit
+	t.onCommit, t.onRollback = nil, nil
+	for _, callback := range callbacks {
+		t.safe("OnCommit", func() { callback(t.ctx) })
+	}
+}
+
+// afterRollback calls OnRollback callbacks once
+func (t *Tx) afterRollback(err error) {
+	callbacks := t.onRollback
+	t.onCommit, t.onRollback = nil, nil
+	for _, callback := range callbacks {
+		t.safe("OnRollback", func() { callback(t.ctx, err) })
+	}
+}
+
+// done marks transaction as finished for Shutdown
+func (t *Tx) done() {
+	if t.release != nil {
+		t.release()
+	}
+}
+
+func (t *Tx) exec(ctx context.Context, fname string, query string) error {
+	start := time.Now()
+	_, err := t.q().ExecContext(ctx, query)
+	t.log(ctx, fname, start, err, true, query)
+	return err
+}
+
+// finish ends transaction by query on dedicated connection or by driver
+func (t *Tx) finish(query string, end func() error) error {
+	if t.conn == nil {
+		return end()
+	}
+	_, err := t.conn.ExecContext(context.Background(), query)
+	if err != nil && query == "COMMIT" {
+		// SQLite keeps transaction open when commit fails
+		_, _ = t.conn.ExecContext(context.Background(), "ROLLBACK")
+	}
+	for _, stmt := range t.stmts {
+		_ = stmt.Close()
+	}
+	for _, query := range t.reset {
+		if rerr := t.exec(context.Background(), "Reset", query); rerr != nil {
+			// Connection with not reset state must not be reused
+			_ = t.conn.Raw(func(any) error { return driver.ErrBadConn })
+			break
+		}
+	}
+	_ = t.conn.Close()
+	return err
 }
 
-func (t *Tx) fixQuery(query string) string {
-	if t.Driver == "mysql" {
-		return fixQuery(query)
+// q returns connection or transaction of driver for queries
+func (t *Tx) q() txConn {
+	if t.conn != nil {
+		return t.conn
 	}
-	return query
+	return t.tx
 }
 
-func (t *Tx) log(fname string, start time.Time, err error, tx bool, query string, args ...any) {
+func (t *Tx) fixQuery(query string, args []any) (string, []any, error) {
+	return fixQueryArgs(t.Dialect, query, args)
+}
+
+// rollback rolls back transaction and passes cause to OnRollback callbacks
+func (t *Tx) rollback(cause error) error {
+	start := time.Now()
+	err := t.finish("ROLLBACK", t.tx.Rollback)
+	t.log(context.Background(), "Rollback", start, err, true, "")
+	t.done()
+	t.afterRollback(cause)
+	return err
+}
+
+// safe calls fn and reports its panic by log and hooks
+func (t *Tx) safe(fname string, fn func()) {
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			t.log(t.ctx, fname, start, fmt.Errorf("panic: %v", p), true, "")
+		}
+	}()
+	fn()
+}
+
+func (t *Tx) log(ctx context.Context, fname string, start time.Time, err error, tx bool, query string, args ...any) {
 	if t.Debug {
-		log(os.Stdout, fname, start, err, tx, query, args...)
+		log(t.db.logger(), fname, start, err, tx, query, args...)
 	}
+	runHooks(ctx, t.db.Hooks, fname, start, err, tx, query, args...)
 }
 
 func (t *Tx) Commit() error {
-	err := t.tx.Commit()
-	t.log("Commit", t.start, err, true, "")
+	start := time.Now()
+	err := t.finish("COMMIT", t.tx.Commit)
+	t.log(context.Background(), "Commit", start, err, true, "")
+	t.done()
+	if err != nil {
+		t.afterRollback(err)
+	} else {
+		t.afterCommit()
+	}
 	return err
 }
 
@@ -41,7 +161,7 @@ func (t *Tx) CurrentUnixTimestamp() int64 {
 }
 
 func (t *Tx) DeleteRowByID(ctx context.Context, id int64, row any) error {
-	query := deleteRowByIDString(row)
+	query := deleteRowByIDString(t.Dialect, row)
 	_, err := t.Exec(ctx, query, id)
 	return err
 }
@@ -77,9 +197,16 @@ func (t *Tx) EachPrepared(ctx context.Context, prep *Prepared, callback func(ctx
 
 func (t *Tx) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
 	start := time.Now()
-	res, err := t.tx.ExecContext(ctx, t.fixQuery(query), args...)
-	t.log("Exec", start, err, true, t.fixQuery(query), args...)
-	return res, err
+	query, args, err := t.fixQuery(query, args)
+	if err != nil {
+		t.log(ctx, "Exec", start, err, true, query, args...)
+		return nil, queryError("Exec", query, len(args), start, err)
+	}
+	ctx, cancel := t.db.timeout(ctx)
+	defer cancel()
+	res, err := t.q().ExecContext(ctx, query, args...)
+	t.log(ctx, "Exec", start, err, true, query, args...)
+	return res, queryError("Exec", query, len(args), start, err)
 }
 
 func (t *Tx) ExecPrepared(ctx context.Context, prep *Prepared) (sql.Result, error) {
@@ -87,20 +214,90 @@ func (t *Tx) ExecPrepared(ctx context.Context, prep *Prepared) (sql.Result, erro
 }
 
 func (t *Tx) InsertRow(ctx context.Context, row any) error {
-	query, args := insertRowString(row)
+	query, args := insertRowString(t.Dialect, row)
 	_, err := t.Exec(ctx, query, args...)
 	return err
 }
 
+func (t *Tx) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	q, args, err := namedQuery(t.Dialect, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return t.Exec(ctx, q, args...)
+}
+
+func (t *Tx) NamedQuery(ctx context.Context, query string, arg any) (*Rows, error) {
+	q, args, err := namedQuery(t.Dialect, query, arg)
+	if err != nil {
+		return nil, err
+	}
+	return t.Query(ctx, q, args...)
+}
+
+func (t *Tx) NamedQueryRow(ctx context.Context, query string, arg any) *Row {
+	q, args, err := namedQuery(t.Dialect, query, arg)
+	if err != nil {
+		return &Row{err: err}
+	}
+	return t.QueryRow(ctx, q, args...)
+}
+
+func (t *Tx) Paginate(ctx context.Context, query string, page Page, callback func(ctx context.Context, rows *Rows) error, args ...any) (*PageResult, error) {
+	return paginate(ctx, t, t.Dialect, query, page, callback, args...)
+}
+
+// Ping checks connection of transaction
+func (t *Tx) Ping(ctx context.Context) error {
+	start := time.Now()
+	var err error
+	if t.conn != nil {
+		err = t.conn.PingContext(ctx)
+	} else {
+		var one int
+		err = t.tx.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+	}
+	t.log(ctx, "Ping", start, err, true, "")
+	return err
+}
+
+// Prepare creates prepared statement of transaction, it's closed when
+// transaction is finished
+func (t *Tx) Prepare(ctx context.Context, query string) (*Stmt, error) {
+	start := time.Now()
+	fixed, err := fixPrepareQuery(t.Dialect, query)
+	var stmt *sql.Stmt
+	if err == nil {
+		stmt, err = t.q().PrepareContext(ctx, fixed)
+	}
+	t.log(ctx, "Prepare", start, err, true, fixed)
+	if err != nil {
+		return nil, queryError("Prepare", fixed, 0, start, err)
+	}
+	if t.conn != nil {
+		t.stmts = append(t.stmts, stmt)
+	}
+	return &Stmt{db: t.db, query: query, stmt: stmt, tx: true}, nil
+}
+
 func (t *Tx) PrepareSQL(query string, args ...any) *Prepared {
 	return prepareSQL(query, args...)
 }
 
 func (t *Tx) Query(ctx context.Context, query string, args ...any) (*Rows, error) {
 	start := time.Now()
-	rows, err := t.tx.QueryContext(ctx, t.fixQuery(query), args...)
-	t.log("Query", start, err, true, t.fixQuery(query), args...)
-	return &Rows{Rows: rows}, err
+	query, args, err := t.fixQuery(query, args)
+	if err != nil {
+		t.log(ctx, "Query", start, err, true, query, args...)
+		return &Rows{}, queryError("Query", query, len(args), start, err)
+	}
+	ctx, cancel := t.db.timeout(ctx)
+	rows, err := t.q().QueryContext(ctx, query, args...)
+	t.log(ctx, "Query", start, err, true, query, args...)
+	if err != nil {
+		cancel()
+	}
+	return &Rows{Rows: rows, cancel: cancel}, queryError("Query", query, len(args), start, err)
 }
 
 func (t *Tx) QueryPrepared(ctx context.Context, prep *Prepared) (*Rows, error) {
@@ -109,13 +306,28 @@ func (t *Tx) QueryPrepared(ctx context.Context, prep *Prepared) (*Rows, error) {
 
 func (t *Tx) QueryRow(ctx context.Context, query string, args ...any) *Row {
 	start := time.Now()
-	row := t.tx.QueryRowContext(ctx, t.fixQuery(query), args...)
-	t.log("QueryRow", start, nil, true, t.fixQuery(query), args...)
-	return &Row{Row: row}
+	query, args, err := t.fixQuery(query, args)
+	if err != nil {
+		t.log(ctx, "QueryRow", start, err, true, query, args...)
+		return &Row{err: queryError("QueryRow", query, len(args), start, err)}
+	}
+	ctx, cancel := t.db.timeout(ctx)
+	row := t.q().QueryRowContext(ctx, query, args...)
+	if t.Debug {
+		log(t.db.logger(), "QueryRow", start, nil, true, query, args...)
+	}
+	return &Row{
+		Row:    row,
+		hook:   rowHooks(ctx, t.db.Hooks, "QueryRow", start, true, query, args...),
+		cancel: cancel,
+		wrap: func(err error) error {
+			return queryError("QueryRow", query, len(args), start, err)
+		},
+	}
 }
 
 func (t *Tx) QueryRowByID(ctx context.Context, id int64, row any) error {
-	query := queryRowByIDString(row)
+	query := queryRowByIDString(t.Dialect, row)
 	return t.QueryRow(ctx, query, id).Scans(row)
 }
 
@@ -125,27 +337,120 @@ func (t *Tx) QueryRowPrepared(ctx context.Context, prep *Prepared) *Row {
 
 func (t *Tx) RowExists(ctx context.Context, id int64, row any) bool {
 	var exists int
-	query := rowExistsString(row)
+	query := rowExistsString(t.Dialect, row)
 	if err := t.QueryRow(ctx, query, id).Scan(&exists); err == nil && exists == 1 {
 		return true
 	}
 	return false
 }
 
+// OnCommit registers callback which is called after successful commit,
+// callbacks are called in order of registration and their panics are logged
+func (t *Tx) OnCommit(callback func(ctx context.Context)) {
+	t.onCommit = append(t.onCommit, callback)
+}
+
+// OnRollback registers callback which is called after rollback with error
+// which caused it, error is nil when Rollback is called directly
+func (t *Tx) OnRollback(callback func(ctx context.Context, err error)) {
+	t.onRollback = append(t.onRollback, callback)
+}
+
 func (t *Tx) Rollback() error {
-	err := t.tx.Rollback()
-	t.log("Rollback", t.start, err, true, "")
-	return err
+	return t.rollback(nil)
+}
+
+// Stmt returns statement of transaction for statement prepared by engine,
+// it's created once per transaction and closed when transaction is finished
+func (t *Tx) Stmt(ctx context.Context, stmt *Stmt) *Stmt {
+	t.cacheMu.Lock()
+	defer t.cacheMu.Unlock()
+	if res, ok := t.cache[stmt]; ok {
+		return res
+	}
+	res := t.stmt(ctx, stmt)
+	if res.err == nil {
+		if t.cache == nil {
+			t.cache = map[*Stmt]*Stmt{}
+		}
+		t.cache[stmt] = res
+	}
+	return res
+}
+
+func (t *Tx) stmt(ctx context.Context, stmt *Stmt) *Stmt {
+	if t.conn == nil {
+		if stmt.err != nil {
+			return &Stmt{db: t.db, query: stmt.query, tx: true, err: stmt.err}
+		}
+		return &Stmt{db: t.db, query: stmt.query, stmt: t.tx.StmtContext(ctx, stmt.stmt), tx: true}
+	}
+	// Statement is prepared again on dedicated connection
+	res, err := t.Prepare(ctx, stmt.query)
+	if err != nil {
+		return &Stmt{db: t.db, query: stmt.query, tx: true, err: err}
+	}
+	return res
+}
+
+// Transaction runs callback inside savepoint of transaction, savepoint is
+// released when callback succeeds and rolled back when it fails or panics,
+// so changes of callback are discarded, but transaction can be continued
+func (t *Tx) Transaction(ctx context.Context, callback func(ctx context.Context, tx *Tx) error) error {
+	if callback == nil {
+		return fmt.Errorf("callback is not set")
+	}
+	t.savepoints++
+	name := fmt.Sprintf("gosql_savepoint_%d", t.savepoints)
+	if err := t.exec(ctx, "Savepoint", "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	commits, rollbacks := len(t.onCommit), len(t.onRollback)
+	// rollback rolls back savepoint, callbacks of savepoint are finished
+	// with it
+	rollback := func(cause error) error {
+		if err := t.exec(ctx, "RollbackToSavepoint", "ROLLBACK TO SAVEPOINT "+name); err != nil {
+			return err
+		}
+		callbacks := slices.Clone(t.onRollback[rollbacks:])
+		t.onCommit, t.onRollback = t.onCommit[:commits], t.onRollback[:rollbacks]
+		for _, callback := range callbacks {
+			t.safe("OnRollback", func() { callback(t.ctx, cause) })
+		}
+		return nil
+	}
+	start := time.Now()
+	defer func() {
+		if p := recover(); p != nil {
+			err := fmt.Errorf("panic: %v", p)
+			if rerr := rollback(err); rerr != nil {
+				err = fmt.Errorf("%w, rollback failed: %v", err, rerr)
+			}
+			t.log(ctx, "Transaction", start, err, true, "")
+			panic(p)
+		}
+	}()
+	if err := callback(ContextWithTx(ctx, t), t); err != nil {
+		if rerr := rollback(err); rerr != nil {
+			return fmt.Errorf(
+				"%v: %v",
+				rerr,
+				err,
+			)
+		}
+		return err
+	}
+	return t.exec(ctx, "ReleaseSavepoint", "RELEASE SAVEPOINT "+name)
 }
 
 func (t *Tx) UpdateRow(ctx context.Context, row any) error {
-	query, args := updateRowString(row)
+	query, args := updateRowString(t.Dialect, row)
 	_, err := t.Exec(ctx, query, args...)
 	return err
 }
 
 func (t *Tx) UpdateRowOnly(ctx context.Context, row any, fields ...string) error {
-	query, args := updateRowString(row, fields...)
+	query, args := updateRowString(t.Dialect, row, fields...)
 	_, err := t.Exec(ctx, query, args...)
 	return err
 }
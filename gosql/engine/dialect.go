@@ -0,0 +1,296 @@
+package engine
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/vladimirok5959/golang-sql/gosql/common"
+)
+
+func init() {
+	common.RegisterDialect("mysql", MySQLDialect{})
+	common.RegisterDialect("postgres", PostgreSQLDialect{})
+	common.RegisterDialect("postgresql", PostgreSQLDialect{})
+	common.RegisterDialect("sqlite", SQLiteDialect{})
+	common.RegisterDialect("sqlite3", SQLiteDialect{})
+}
+
+// DialectByName returns dialect of built-in or registered engine by its URL
+// scheme
+func DialectByName(name string) (common.Dialect, error) {
+	if _, dialect, ok := common.LookupEngine(name); ok {
+		return dialect, nil
+	}
+	switch name {
+	case "mysql":
+		return MySQLDialect{}, nil
+	case "postgres", "postgresql":
+		return PostgreSQLDialect{}, nil
+	case "sqlite", "sqlite3":
+		return SQLiteDialect{}, nil
+	}
+	return nil, fmt.Errorf("unsupported dialect: %s", name)
+}
+
+func limitOffset(limit, offset int64, noLimit string) string {
+	var clause string
+	if limit > 0 {
+		clause = "LIMIT " + strconv.FormatInt(limit, 10)
+	} else if offset > 0 && noLimit != "" {
+		clause = "LIMIT " + noLimit
+	}
+	if offset > 0 {
+		if clause != "" {
+			clause += " "
+		}
+		clause += "OFFSET " + strconv.FormatInt(offset, 10)
+	}
+	return clause
+}
+
+func isolationError(d common.Dialect, level sql.IsolationLevel) error {
+	return fmt.Errorf("isolation level is not supported by %s: %s", d.Name(), level)
+}
+
+func quoteIdent(name string, quote string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = quote + strings.ReplaceAll(part, quote, quote+quote) + quote
+	}
+	return strings.Join(parts, ".")
+}
+
+// ----------------------------------------------------------------------------
+
+type MySQLDialect struct{}
+
+func (MySQLDialect) Bool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func (MySQLDialect) CurrentTimestamp() string {
+	return "NOW()"
+}
+
+func (MySQLDialect) LimitOffset(limit, offset int64) string {
+	return limitOffset(limit, offset, "18446744073709551615")
+}
+
+// LockMode uses GET_LOCK and RELEASE_LOCK, note: name is limited by 64
+// characters
+func (MySQLDialect) LockMode() common.LockMode {
+	return common.LockMode{
+		Lock:    "SELECT GET_LOCK($1, -1)",
+		TryLock: "SELECT GET_LOCK($1, 0)",
+		Unlock:  "SELECT RELEASE_LOCK($1)",
+	}
+}
+
+func (MySQLDialect) Name() string {
+	return "mysql"
+}
+
+func (MySQLDialect) Placeholder() common.PlaceholderStyle {
+	return common.PlaceholderQuestion
+}
+
+func (MySQLDialect) QuoteIdent(name string) string {
+	return quoteIdent(name, "`")
+}
+
+// Retryable reports deadlock (1213) and lock wait timeout (1205)
+func (MySQLDialect) Retryable(err error) bool {
+	var e *mysqldriver.MySQLError
+	return errors.As(err, &e) && (e.Number == 1213 || e.Number == 1205)
+}
+
+func (MySQLDialect) Returning() bool {
+	return false
+}
+
+// TxMode passes options to driver, all standard isolation levels and read-only
+// mode are supported
+func (d MySQLDialect) TxMode(opts *sql.TxOptions) (common.TxMode, error) {
+	if opts != nil {
+		switch opts.Isolation {
+		case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable:
+		default:
+			return common.TxMode{}, isolationError(d, opts.Isolation)
+		}
+	}
+	return common.TxMode{Options: opts}, nil
+}
+
+func (d MySQLDialect) Upsert(conflict []string, update []string) string {
+	if len(update) == 0 {
+		// MySQL has no DO NOTHING, so update any column to itself
+		column := "id"
+		if len(conflict) > 0 {
+			column = conflict[0]
+		}
+		return "ON DUPLICATE KEY UPDATE " + d.QuoteIdent(column) + " = " + d.QuoteIdent(column)
+	}
+	values := make([]string, len(update))
+	for i, column := range update {
+		values[i] = d.QuoteIdent(column) + " = VALUES(" + d.QuoteIdent(column) + ")"
+	}
+	return "ON DUPLICATE KEY UPDATE " + strings.Join(values, ", ")
+}
+
+// ----------------------------------------------------------------------------
+
+type PostgreSQLDialect struct{}
+
+func (PostgreSQLDialect) Bool(value bool) string {
+	if value {
+		return "TRUE"
+	}
+	return "FALSE"
+}
+
+func (PostgreSQLDialect) CurrentTimestamp() string {
+	return "NOW()"
+}
+
+func (PostgreSQLDialect) LimitOffset(limit, offset int64) string {
+	return limitOffset(limit, offset, "")
+}
+
+// LockMode uses advisory locks with key from FNV hash of name
+func (PostgreSQLDialect) LockMode() common.LockMode {
+	return common.LockMode{
+		Key: func(name string) any {
+			h := fnv.New64a()
+			h.Write([]byte(name))
+			return int64(h.Sum64())
+		},
+		Lock:    "SELECT TRUE FROM (SELECT pg_advisory_lock($1)) AS l",
+		TryLock: "SELECT pg_try_advisory_lock($1)",
+		Unlock:  "SELECT pg_advisory_unlock($1)",
+	}
+}
+
+func (PostgreSQLDialect) Name() string {
+	return "postgres"
+}
+
+func (PostgreSQLDialect) Placeholder() common.PlaceholderStyle {
+	return common.PlaceholderDollar
+}
+
+func (PostgreSQLDialect) QuoteIdent(name string) string {
+	return quoteIdent(name, `"`)
+}
+
+// Retryable reports serialization failure (40001) and deadlock (40P01)
+func (PostgreSQLDialect) Retryable(err error) bool {
+	var e *pq.Error
+	return errors.As(err, &e) && (e.Code == "40001" || e.Code == "40P01")
+}
+
+func (PostgreSQLDialect) Returning() bool {
+	return true
+}
+
+// TxMode passes options to driver, all standard isolation levels and read-only
+// mode are supported, note: PostgreSQL runs READ UNCOMMITTED as READ COMMITTED
+func (d PostgreSQLDialect) TxMode(opts *sql.TxOptions) (common.TxMode, error) {
+	if opts != nil {
+		switch opts.Isolation {
+		case sql.LevelDefault, sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable:
+		default:
+			return common.TxMode{}, isolationError(d, opts.Isolation)
+		}
+	}
+	return common.TxMode{Options: opts}, nil
+}
+
+func (d PostgreSQLDialect) Upsert(conflict []string, update []string) string {
+	return common.OnConflict(d, conflict, update)
+}
+
+// ----------------------------------------------------------------------------
+
+type SQLiteDialect struct{}
+
+func (SQLiteDialect) Bool(value bool) string {
+	if value {
+		return "1"
+	}
+	return "0"
+}
+
+func (SQLiteDialect) CurrentTimestamp() string {
+	return "CURRENT_TIMESTAMP"
+}
+
+func (SQLiteDialect) LimitOffset(limit, offset int64) string {
+	return limitOffset(limit, offset, "-1")
+}
+
+// LockMode uses lock table, because SQLite has no named locks
+func (SQLiteDialect) LockMode() common.LockMode {
+	return common.LockMode{}
+}
+
+func (SQLiteDialect) Name() string {
+	return "sqlite"
+}
+
+func (SQLiteDialect) Placeholder() common.PlaceholderStyle {
+	return common.PlaceholderNumbered
+}
+
+func (SQLiteDialect) QuoteIdent(name string) string {
+	return quoteIdent(name, `"`)
+}
+
+// Retryable reports SQLITE_BUSY and SQLITE_LOCKED
+func (SQLiteDialect) Retryable(err error) bool {
+	var e sqlite3.Error
+	return errors.As(err, &e) && (e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked)
+}
+
+func (SQLiteDialect) Returning() bool {
+	return true
+}
+
+// TxMode supports default and serializable isolation levels only, because
+// SQLite transactions are always serializable. Driver ignores options, so
+// serializable transaction is started by BEGIN IMMEDIATE, which takes write
+// lock at once, and read-only one by BEGIN DEFERRED with query_only pragma,
+// which is reset on the same connection. Default transaction is started by
+// driver, so _txlock URL param is used for it
+func (d SQLiteDialect) TxMode(opts *sql.TxOptions) (common.TxMode, error) {
+	if opts == nil {
+		return common.TxMode{}, nil
+	}
+	if opts.Isolation != sql.LevelDefault && opts.Isolation != sql.LevelSerializable {
+		return common.TxMode{}, isolationError(d, opts.Isolation)
+	}
+	if opts.ReadOnly {
+		return common.TxMode{
+			Start: "BEGIN DEFERRED",
+			Begin: []string{"PRAGMA query_only = ON"},
+			Reset: []string{"PRAGMA query_only = OFF"},
+		}, nil
+	}
+	if opts.Isolation == sql.LevelSerializable {
+		return common.TxMode{Start: "BEGIN IMMEDIATE"}, nil
+	}
+	return common.TxMode{}, nil
+}
+
+func (d SQLiteDialect) Upsert(conflict []string, update []string) string {
+	return common.OnConflict(d, conflict, update)
+}
@@ -1,6 +1,7 @@
 package engine
 
 import (
+	"database/sql"
 	"net/url"
 
 	"github.com/vladimirok5959/golang-sql/gosql/common"
@@ -8,6 +9,44 @@ import (
 
 // ----------------------------------------------------------------------------
 
+type custom struct {
+	*common.DBMethods
+}
+
+// New builds engine around configured methods, engine type is chosen by
+// dialect, so built-in dialects get built-in engines
+func New(d *common.DBMethods) common.Engine {
+	switch d.Dialect.(type) {
+	case MySQLDialect:
+		return &mysql{DBMethods: d}
+	case PostgreSQLDialect:
+		return &postgresql{DBMethods: d}
+	case SQLiteDialect:
+		return &sqlite{DBMethods: d}
+	default:
+		return &custom{DBMethods: d}
+	}
+}
+
+// sumStats returns sum of connections pools statistics
+func sumStats(stats ...sql.DBStats) sql.DBStats {
+	var sum sql.DBStats
+	for _, st := range stats {
+		sum.MaxOpenConnections += st.MaxOpenConnections
+		sum.OpenConnections += st.OpenConnections
+		sum.InUse += st.InUse
+		sum.Idle += st.Idle
+		sum.WaitCount += st.WaitCount
+		sum.WaitDuration += st.WaitDuration
+		sum.MaxIdleClosed += st.MaxIdleClosed
+		sum.MaxIdleTimeClosed += st.MaxIdleTimeClosed
+		sum.MaxLifetimeClosed += st.MaxLifetimeClosed
+	}
+	return sum
+}
+
+// ----------------------------------------------------------------------------
+
 type mysql struct {
 	*common.DBMethods
 }
@@ -20,9 +59,10 @@ func NewMySQL(dbURL *url.URL, migrationsDir string, skipMigration bool, debug bo
 
 	return &mysql{
 		DBMethods: &common.DBMethods{
-			DB:     db,
-			Debug:  debug,
-			Driver: dbURL.Scheme,
+			DB:      db,
+			Debug:   debug,
+			Dialect: MySQLDialect{},
+			Driver:  dbURL.Scheme,
 		},
 	}, nil
 }
@@ -41,9 +81,10 @@ func NewPostgreSQL(dbURL *url.URL, migrationsDir string, skipMigration bool, deb
 
 	return &postgresql{
 		DBMethods: &common.DBMethods{
-			DB:     db,
-			Debug:  debug,
-			Driver: dbURL.Scheme,
+			DB:      db,
+			Debug:   debug,
+			Dialect: PostgreSQLDialect{},
+			Driver:  dbURL.Scheme,
 		},
 	}, nil
 }
@@ -62,9 +103,10 @@ func NewSQLite(dbURL *url.URL, migrationsDir string, skipMigration bool, debug b
 
 	return &sqlite{
 		DBMethods: &common.DBMethods{
-			DB:     db,
-			Debug:  debug,
-			Driver: dbURL.Scheme,
+			DB:      db,
+			Debug:   debug,
+			Dialect: SQLiteDialect{},
+			Driver:  dbURL.Scheme,
 		},
 	}, nil
 }
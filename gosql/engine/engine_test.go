@@ -0,0 +1,598 @@
+package engine_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vladimirok5959/golang-sql/gosql/common"
+	"github.com/vladimirok5959/golang-sql/gosql/engine"
+)
+
+var _ = Describe("engine", func() {
+	Context("DialectByName", func() {
+		It("return built-in dialects", func() {
+			for name, dialect := range map[string]common.Dialect{
+				"mysql":      engine.MySQLDialect{},
+				"postgres":   engine.PostgreSQLDialect{},
+				"postgresql": engine.PostgreSQLDialect{},
+				"sqlite":     engine.SQLiteDialect{},
+				"sqlite3":    engine.SQLiteDialect{},
+			} {
+				d, err := engine.DialectByName(name)
+				Expect(err).To(Succeed())
+				Expect(d).To(Equal(dialect))
+			}
+		})
+
+		It("fail for unknown dialect", func() {
+			_, err := engine.DialectByName("example")
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("unsupported dialect: example"))
+		})
+	})
+
+	Context("Dialect", func() {
+		It("for MySQL", func() {
+			d := engine.MySQLDialect{}
+
+			Expect(d.Name()).To(Equal("mysql"))
+			Expect(d.Placeholder()).To(Equal(common.PlaceholderQuestion))
+			Expect(d.Bool(true)).To(Equal("1"))
+			Expect(d.Bool(false)).To(Equal("0"))
+			Expect(d.CurrentTimestamp()).To(Equal("NOW()"))
+			Expect(d.LimitOffset(10, 0)).To(Equal("LIMIT 10"))
+			Expect(d.LimitOffset(10, 20)).To(Equal("LIMIT 10 OFFSET 20"))
+			Expect(d.LimitOffset(0, 20)).To(Equal("LIMIT 18446744073709551615 OFFSET 20"))
+			Expect(d.Returning()).To(BeFalse())
+			Expect(d.Upsert([]string{"id"}, []string{"name", "value"})).To(Equal(
+				"ON DUPLICATE KEY UPDATE `name` = VALUES(`name`), `value` = VALUES(`value`)",
+			))
+			Expect(d.Upsert([]string{"id"}, nil)).To(Equal("ON DUPLICATE KEY UPDATE `id` = `id`"))
+			Expect(d.Retryable(fmt.Errorf("commit: %w", &mysql.MySQLError{Number: 1213}))).To(BeTrue())
+			Expect(d.Retryable(&mysql.MySQLError{Number: 1205})).To(BeTrue())
+
+			mode, err := d.TxMode(&sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+			Expect(err).To(Succeed())
+			Expect(mode).To(Equal(common.TxMode{Options: &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true}}))
+
+			_, err = d.TxMode(&sql.TxOptions{Isolation: sql.LevelSnapshot})
+			Expect(err.Error()).To(Equal("isolation level is not supported by mysql: Snapshot"))
+			Expect(d.Retryable(&mysql.MySQLError{Number: 1062})).To(BeFalse())
+			Expect(d.Retryable(errors.New("example"))).To(BeFalse())
+
+			lock := d.LockMode()
+			Expect(lock.Key).To(BeNil())
+			Expect(lock.Lock).To(Equal("SELECT GET_LOCK($1, -1)"))
+			Expect(lock.TryLock).To(Equal("SELECT GET_LOCK($1, 0)"))
+			Expect(lock.Unlock).To(Equal("SELECT RELEASE_LOCK($1)"))
+		})
+
+		It("for PostgreSQL", func() {
+			d := engine.PostgreSQLDialect{}
+
+			Expect(d.Name()).To(Equal("postgres"))
+			Expect(d.Placeholder()).To(Equal(common.PlaceholderDollar))
+			Expect(d.Bool(true)).To(Equal("TRUE"))
+			Expect(d.Bool(false)).To(Equal("FALSE"))
+			Expect(d.CurrentTimestamp()).To(Equal("NOW()"))
+			Expect(d.LimitOffset(10, 0)).To(Equal("LIMIT 10"))
+			Expect(d.LimitOffset(10, 20)).To(Equal("LIMIT 10 OFFSET 20"))
+			Expect(d.LimitOffset(0, 20)).To(Equal("OFFSET 20"))
+			Expect(d.Returning()).To(BeTrue())
+			Expect(d.Upsert([]string{"id"}, []string{"name", "value"})).To(Equal(
+				`ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name", "value" = excluded."value"`,
+			))
+			Expect(d.Upsert([]string{"id"}, nil)).To(Equal(`ON CONFLICT ("id") DO NOTHING`))
+			Expect(d.Retryable(fmt.Errorf("commit: %w", &pq.Error{Code: "40001"}))).To(BeTrue())
+			Expect(d.Retryable(&pq.Error{Code: "40P01"})).To(BeTrue())
+
+			mode, err := d.TxMode(&sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+			Expect(err).To(Succeed())
+			Expect(mode).To(Equal(common.TxMode{Options: &sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true}}))
+
+			_, err = d.TxMode(&sql.TxOptions{Isolation: sql.LevelLinearizable})
+			Expect(err.Error()).To(Equal("isolation level is not supported by postgres: Linearizable"))
+			Expect(d.Retryable(&pq.Error{Code: "23505"})).To(BeFalse())
+			Expect(d.Retryable(errors.New("example"))).To(BeFalse())
+
+			lock := d.LockMode()
+			Expect(lock.Key("job")).To(Equal(lock.Key("job")))
+			Expect(lock.Key("job")).NotTo(Equal(lock.Key("other")))
+			Expect(lock.Lock).To(Equal("SELECT TRUE FROM (SELECT pg_advisory_lock($1)) AS l"))
+			Expect(lock.TryLock).To(Equal("SELECT pg_try_advisory_lock($1)"))
+			Expect(lock.Unlock).To(Equal("SELECT pg_advisory_unlock($1)"))
+		})
+
+		It("for SQLite", func() {
+			d := engine.SQLiteDialect{}
+
+			Expect(d.Name()).To(Equal("sqlite"))
+			Expect(d.Placeholder()).To(Equal(common.PlaceholderNumbered))
+			Expect(d.Bool(true)).To(Equal("1"))
+			Expect(d.Bool(false)).To(Equal("0"))
+			Expect(d.CurrentTimestamp()).To(Equal("CURRENT_TIMESTAMP"))
+			Expect(d.LimitOffset(10, 0)).To(Equal("LIMIT 10"))
+			Expect(d.LimitOffset(10, 20)).To(Equal("LIMIT 10 OFFSET 20"))
+			Expect(d.LimitOffset(0, 20)).To(Equal("LIMIT -1 OFFSET 20"))
+			Expect(d.Returning()).To(BeTrue())
+			Expect(d.Upsert([]string{"id"}, []string{"name"})).To(Equal(
+				`ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"`,
+			))
+			Expect(d.Retryable(fmt.Errorf("commit: %w", sqlite3.Error{Code: sqlite3.ErrBusy}))).To(BeTrue())
+			Expect(d.Retryable(sqlite3.Error{Code: sqlite3.ErrLocked})).To(BeTrue())
+
+			mode, err := d.TxMode(nil)
+			Expect(err).To(Succeed())
+			Expect(mode).To(Equal(common.TxMode{}))
+
+			mode, err = d.TxMode(&sql.TxOptions{Isolation: sql.LevelSerializable, ReadOnly: true})
+			Expect(err).To(Succeed())
+			Expect(mode).To(Equal(common.TxMode{
+				Start: "BEGIN DEFERRED",
+				Begin: []string{"PRAGMA query_only = ON"},
+				Reset: []string{"PRAGMA query_only = OFF"},
+			}))
+
+			mode, err = d.TxMode(&sql.TxOptions{Isolation: sql.LevelSerializable})
+			Expect(err).To(Succeed())
+			Expect(mode).To(Equal(common.TxMode{Start: "BEGIN IMMEDIATE"}))
+
+			mode, err = d.TxMode(&sql.TxOptions{})
+			Expect(err).To(Succeed())
+			Expect(mode).To(Equal(common.TxMode{}))
+
+			_, err = d.TxMode(&sql.TxOptions{Isolation: sql.LevelReadCommitted})
+			Expect(err.Error()).To(Equal("isolation level is not supported by sqlite: Read Committed"))
+			Expect(d.Retryable(sqlite3.Error{Code: sqlite3.ErrConstraint})).To(BeFalse())
+			Expect(d.Retryable(errors.New("example"))).To(BeFalse())
+			Expect(d.LockMode()).To(Equal(common.LockMode{}))
+		})
+
+		It("for custom dialect", func() {
+			d := customDialect{}
+
+			Expect(common.Bool(d, true)).To(Equal("TRUE"))
+			Expect(common.Bool(d, false)).To(Equal("FALSE"))
+			Expect(common.CurrentTimestamp(d)).To(Equal("CURRENT_TIMESTAMP"))
+			Expect(common.Returning(d)).To(BeFalse())
+			Expect(common.Upsert(d, []string{"id"}, []string{"name"})).To(Equal(
+				`ON CONFLICT ("id") DO UPDATE SET "name" = excluded."name"`,
+			))
+			Expect(common.Upsert(d, nil, nil)).To(Equal("ON CONFLICT DO NOTHING"))
+
+			Expect(common.Bool(engine.PostgreSQLDialect{}, true)).To(Equal("TRUE"))
+			Expect(common.Upsert(engine.MySQLDialect{}, nil, nil)).To(Equal("ON DUPLICATE KEY UPDATE `id` = `id`"))
+		})
+	})
+
+	Context("DBMethods", func() {
+		It("use dialect of driver when dialect is not set", func() {
+			ctx := context.Background()
+
+			node := openNode("a")
+			db := &common.DBMethods{DB: node.DB, Driver: "sqlite"}
+
+			_, err := db.Exec(ctx, "insert into node (name) values ($2), ($1)", "c", "b")
+			Expect(err).To(Succeed())
+
+			var name string
+			Expect(db.QueryRow(ctx, "select name from node where rowid = $1", 2).Scan(&name)).To(Succeed())
+			Expect(name).To(Equal("b"))
+
+			_, err = db.Exec(ctx, "insert into node (name) values ($1)", "d", "e")
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("Exec: extra arguments: 2, highest placeholder is $1 (query: insert into node (name) values (?1))"))
+
+			tx, err := db.Begin(ctx, nil)
+			Expect(err).To(Succeed())
+			Expect(tx.Dialect).To(Equal(engine.SQLiteDialect{}))
+			Expect(tx.Rollback()).To(Succeed())
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("use defaults for dialect without optional interfaces", func() {
+			ctx := context.Background()
+
+			node := openNode("a")
+			db := &common.DBMethods{DB: node.DB, Dialect: customDialect{}, Driver: "custom"}
+
+			tx, err := db.Begin(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+			Expect(err).To(Succeed())
+			Expect(tx.Rollback()).To(Succeed())
+
+			attempts := 0
+			err = db.TransactionRetry(ctx, common.RetryPolicy{}, func(ctx context.Context, tx *common.Tx) error {
+				attempts++
+				return sqlite3.Error{Code: sqlite3.ErrBusy}
+			})
+			Expect(err).To(MatchError(sqlite3.Error{Code: sqlite3.ErrBusy}))
+			Expect(attempts).To(Equal(1))
+
+			Expect(db.TryLock(ctx, "job")).To(BeTrue())
+			Expect(db.Unlock(ctx, "job")).To(Succeed())
+
+			Expect(db.Close()).To(Succeed())
+		})
+	})
+
+	Context("NewReplicated", func() {
+		var ctx = context.Background()
+
+		readName := func(db common.Engine, ctx context.Context) string {
+			var name string
+			Expect(db.QueryRow(ctx, "select name from node").Scan(&name)).To(Succeed())
+			return name
+		}
+
+		It("route reads to replicas and writes to primary", func() {
+			primary := openNode("primary")
+			db := engine.NewReplicated(primary, []*common.DBMethods{
+				openNode("replica1"),
+				openNode("replica2"),
+			}, engine.RoundRobin, 0)
+
+			names := []string{readName(db, ctx), readName(db, ctx), readName(db, ctx)}
+			Expect(names).To(Equal([]string{"replica1", "replica2", "replica1"}))
+
+			Expect(readName(db, engine.WithPrimary(ctx))).To(Equal("primary"))
+
+			_, err := db.Exec(ctx, "update node set name=$1", "updated")
+			Expect(err).To(Succeed())
+			Expect(readName(primary, ctx)).To(Equal("updated"))
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *common.Tx) error {
+				var name string
+				if err := tx.QueryRow(ctx, "select name from node").Scan(&name); err != nil {
+					return err
+				}
+				Expect(name).To(Equal("updated"))
+				return nil
+			})
+			Expect(err).To(Succeed())
+
+			db.SetMaxOpenConns(2)
+			Expect(db.Stats().MaxOpenConnections).To(Equal(6))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("route reads to replica with least connections", func() {
+			replica1 := openNode("replica1")
+			db := engine.NewReplicated(openNode("primary"), []*common.DBMethods{
+				replica1,
+				openNode("replica2"),
+			}, engine.LeastConnections, 0)
+
+			rows, err := replica1.Query(ctx, "select name from node")
+			Expect(err).To(Succeed())
+			Expect(readName(db, ctx)).To(Equal("replica2"))
+			Expect(rows.Close()).To(Succeed())
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("run writes inside transaction of replica", func() {
+			primary := openNode("primary")
+			db := engine.NewReplicated(primary, []*common.DBMethods{
+				openNode("replica"),
+			}, engine.RoundRobin, 0)
+
+			err := db.TransactionWith(ctx, &sql.TxOptions{ReadOnly: true}, func(ctx context.Context, tx *common.Tx) error {
+				Expect(readName(db, ctx)).To(Equal("replica"))
+				_, err := db.Exec(ctx, "update node set name=$1", "updated")
+				return err
+			})
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(ContainSubstring("readonly database"))
+			Expect(readName(primary, ctx)).To(Equal("primary"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("skip unhealthy replicas", func() {
+			replica := openNode("replica")
+			db := engine.NewReplicated(openNode("primary"), []*common.DBMethods{
+				replica,
+			}, engine.RoundRobin, 10*time.Millisecond)
+
+			Expect(readName(db, ctx)).To(Equal("replica"))
+
+			Expect(replica.DB.Close()).To(Succeed())
+			Eventually(func() string {
+				var name string
+				_ = db.QueryRow(ctx, "select name from node").Scan(&name)
+				return name
+			}).Should(Equal("primary"))
+
+			_ = db.Close()
+		})
+	})
+
+	Context("NewSharded", func() {
+		var ctx = context.Background()
+
+		byFirstArg := func(ctx context.Context, args ...any) (int, error) {
+			if len(args) == 0 {
+				return 0, nil
+			}
+			return int(args[0].(int64) % 2), nil
+		}
+
+		It("route calls by shard func", func() {
+			sharded, err := engine.NewSharded([]common.Engine{
+				openNode("shard0"),
+				openNode("shard1"),
+			}, byFirstArg)
+			Expect(err).To(Succeed())
+			var db common.Engine = sharded
+
+			var name string
+			err = db.QueryRow(ctx, "select name from node where $1 > 0", int64(3)).Scan(&name)
+			Expect(err).To(Succeed())
+			Expect(name).To(Equal("shard1"))
+
+			err = db.QueryRow(ctx, "select name from node where $1 > 0", int64(4)).Scan(&name)
+			Expect(err).To(Succeed())
+			Expect(name).To(Equal("shard0"))
+
+			err = db.QueryRow(ctx, "select name from node where $1 > 0", int64(-1)).Scan(&name)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("shard index is out of range: -1"))
+
+			rows, err := db.Query(ctx, "select name from node where $1 > 0", int64(-1))
+			Expect(err).NotTo(Succeed())
+			Expect(rows).NotTo(BeNil())
+
+			shard, err := sharded.Shard(1)
+			Expect(err).To(Succeed())
+			Expect(shard.QueryRow(ctx, "select name from node").Scan(&name)).To(Succeed())
+			Expect(name).To(Equal("shard1"))
+			_, err = sharded.Shard(2)
+			Expect(err.Error()).To(Equal("shard index is out of range: 2"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("keep calls inside transaction on shard of transaction", func() {
+			shard0 := openNode("shard0")
+			db, err := engine.NewSharded([]common.Engine{
+				shard0,
+				openNode("shard1"),
+			}, byFirstArg)
+			Expect(err).To(Succeed())
+
+			rollback := errors.New("rollback")
+			err = db.Transaction(ctx, func(ctx context.Context, tx *common.Tx) error {
+				_, err := db.Exec(ctx, "update node set name=$2 where $1 > 0", int64(2), "updated")
+				Expect(err).To(Succeed())
+
+				var name string
+				Expect(db.QueryRow(ctx, "select name from node").Scan(&name)).To(Succeed())
+				Expect(name).To(Equal("updated"))
+
+				_, err = db.Exec(ctx, "update node set name=$2 where $1 > 0", int64(3), "updated")
+				Expect(err).NotTo(Succeed())
+				Expect(err.Error()).To(Equal("shard 1 is outside of transaction of shard 0"))
+
+				return rollback
+			})
+			Expect(err).To(MatchError(rollback))
+
+			var name string
+			Expect(shard0.QueryRow(ctx, "select name from node").Scan(&name)).To(Succeed())
+			Expect(name).To(Equal("shard0"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("route calls by hash of shard key", func() {
+			fn, err := engine.HashShardKey(2)
+			Expect(err).To(Succeed())
+			db, err := engine.NewSharded([]common.Engine{
+				openNode("shard0"),
+				openNode("shard1"),
+			}, fn)
+			Expect(err).To(Succeed())
+
+			var name string
+			err = db.QueryRow(ctx, "select name from node").Scan(&name)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("shard key is not set"))
+
+			names := map[string]bool{}
+			for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+				shardCtx := engine.WithShardKey(ctx, key)
+				Expect(db.QueryRow(shardCtx, "select name from node").Scan(&name)).To(Succeed())
+
+				// Same key must always go to same shard
+				var again string
+				Expect(db.QueryRow(shardCtx, "select name from node").Scan(&again)).To(Succeed())
+				Expect(again).To(Equal(name))
+
+				names[name] = true
+			}
+			Expect(names).To(HaveLen(2))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("fan out queries to all shards", func() {
+			db, err := engine.NewSharded([]common.Engine{
+				openNode("shard0"),
+				openNode("shard1"),
+				openNode("shard2"),
+			}, byFirstArg)
+			Expect(err).To(Succeed())
+
+			var names []string
+			err = db.EachAll(ctx, "select name from node", func(ctx context.Context, rows *common.Rows) error {
+				var name string
+				if err := rows.Scan(&name); err != nil {
+					return err
+				}
+				names = append(names, name)
+				return nil
+			})
+			Expect(err).To(Succeed())
+			Expect(names).To(ConsistOf("shard0", "shard1", "shard2"))
+
+			count, err := db.ExecAll(ctx, "update node set name=$1", "updated")
+			Expect(err).To(Succeed())
+			Expect(count).To(Equal(int64(3)))
+
+			db.SetMaxOpenConns(2)
+			Expect(db.Stats().MaxOpenConnections).To(Equal(6))
+
+			err = db.EachAll(ctx, "select missing from node", func(ctx context.Context, rows *common.Rows) error {
+				return nil
+			})
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(ContainSubstring("shard 0: Query: no such column: missing (query: select missing from node)"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("fail for invalid shards", func() {
+			_, err := engine.HashShardKey(0)
+			Expect(err.Error()).To(Equal("shard count must be positive: 0"))
+
+			_, err = engine.NewSharded(nil, byFirstArg)
+			Expect(err.Error()).To(Equal("shards are not set"))
+
+			_, err = engine.NewSharded([]common.Engine{nil}, byFirstArg)
+			Expect(err.Error()).To(Equal("shard is nil: 0"))
+
+			_, err = engine.NewSharded([]common.Engine{openNode("shard0")}, nil)
+			Expect(err.Error()).To(Equal("shard func is not set"))
+		})
+	})
+
+	Context("Lock", func() {
+		It("use lock table with lease for SQLite", func() {
+			ctx := context.Background()
+
+			// Two engines of the same database are instances of service
+			a := openNode("a")
+			a.LockLease = time.Second
+			b := &common.DBMethods{DB: a.DB, Dialect: engine.SQLiteDialect{}, Driver: "sqlite"}
+
+			expiresAt := func() int64 {
+				var t int64
+				Expect(a.DB.QueryRow("select expires_at from gosql_locks where name = 'job'").Scan(&t)).To(Succeed())
+				return t
+			}
+
+			Expect(a.TryLock(ctx, "job")).To(BeTrue())
+			Expect(b.TryLock(ctx, "job")).To(BeFalse())
+
+			// Lease is renewed while lock is held
+			Eventually(expiresAt, 5*time.Second, 10*time.Millisecond).Should(BeNumerically(">", expiresAt()))
+			Expect(b.TryLock(ctx, "job")).To(BeFalse())
+			Consistently(a.LockLost(ctx, "job"), 100*time.Millisecond).ShouldNot(BeClosed())
+
+			tctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+			defer cancel()
+			Expect(b.Lock(tctx, "job")).To(MatchError(context.DeadlineExceeded))
+
+			lost := a.LockLost(ctx, "job")
+			go func() {
+				defer GinkgoRecover()
+				Expect(a.Unlock(ctx, "job")).To(Succeed())
+			}()
+			Expect(b.Lock(ctx, "job")).To(Succeed())
+			Expect(lost).To(BeClosed())
+			Expect(a.Unlock(ctx, "job").Error()).To(Equal("lock is not held: job"))
+			Expect(b.Unlock(ctx, "job")).To(Succeed())
+
+			// Lock of stopped instance is taken after lease expiry
+			_, err := a.DB.Exec("insert into gosql_locks (name, owner, expires_at) values (?, ?, ?)", "stale", "crashed", 0)
+			Expect(err).To(Succeed())
+			Expect(b.TryLock(ctx, "stale")).To(BeTrue())
+			Expect(b.Unlock(ctx, "stale")).To(Succeed())
+
+			// Lock is lost when its row is taken by other owner
+			Expect(a.TryLock(ctx, "job")).To(BeTrue())
+			_, err = a.DB.Exec("update gosql_locks set owner = 'other' where name = 'job'")
+			Expect(err).To(Succeed())
+			Eventually(a.LockLost(ctx, "job"), 5*time.Second).Should(BeClosed())
+			Expect(a.Unlock(ctx, "job").Error()).To(Equal("lock lease is expired: job"))
+			Expect(a.LockLost(ctx, "job")).To(BeClosed())
+
+			Expect(a.Close()).To(Succeed())
+		})
+
+		It("release locks on close", func() {
+			ctx := context.Background()
+
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := sql.Open("sqlite3", f.Name())
+			Expect(err).To(Succeed())
+			a := &common.DBMethods{DB: db, Dialect: engine.SQLiteDialect{}, Driver: "sqlite"}
+			Expect(a.TryLock(ctx, "job")).To(BeTrue())
+			Expect(a.Close()).To(Succeed())
+
+			db, err = sql.Open("sqlite3", f.Name())
+			Expect(err).To(Succeed())
+			var count int
+			Expect(db.QueryRow("select count(*) from gosql_locks").Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(0))
+			Expect(db.Close()).To(Succeed())
+		})
+	})
+})
+
+// customDialect is third-party dialect which implements only Dialect
+type customDialect struct{}
+
+func (customDialect) LimitOffset(limit, offset int64) string {
+	return fmt.Sprintf("LIMIT %d OFFSET %d", limit, offset)
+}
+
+func (customDialect) Name() string {
+	return "custom"
+}
+
+func (customDialect) Placeholder() common.PlaceholderStyle {
+	return common.PlaceholderNumbered
+}
+
+func (customDialect) QuoteIdent(name string) string {
+	return `"` + name + `"`
+}
+
+func openNode(name string) *common.DBMethods {
+	f, err := os.CreateTemp("", "go-sqlite-test-")
+	Expect(err).To(Succeed())
+	f.Close()
+
+	db, err := sql.Open("sqlite3", f.Name())
+	Expect(err).To(Succeed())
+
+	_, err = db.Exec("create table node (name varchar(255)); insert into node (name) values (?)", name)
+	Expect(err).To(Succeed())
+
+	return &common.DBMethods{
+		DB:      db,
+		Dialect: engine.SQLiteDialect{},
+		Driver:  "sqlite",
+	}
+}
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "gosql/engine")
+}
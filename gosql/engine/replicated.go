@@ -0,0 +1,342 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vladimirok5959/golang-sql/gosql/common"
+)
+
+// ReplicaPolicy defines how replica is chosen for read queries
+type ReplicaPolicy int
+
+const (
+	// RoundRobin uses healthy replicas one by one
+	RoundRobin ReplicaPolicy = iota
+
+	// LeastConnections uses healthy replica with least count of connections
+	// in use
+	LeastConnections
+)
+
+// DefaultHealthInterval is interval of replicas health checks when it's not
+// set
+const DefaultHealthInterval = 5 * time.Second
+
+type primaryKey struct{}
+
+// WithPrimary returns context which makes replicated engine to send read
+// queries to primary, for example for reading own writes
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+func isPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+type replica struct {
+	*common.DBMethods
+
+	healthy atomic.Bool
+}
+
+// replicated sends Exec, Transaction and other writes to primary and read
+// queries to replicas
+type replicated struct {
+	*common.DBMethods
+
+	next     atomic.Uint64
+	policy   ReplicaPolicy
+	replicas []*replica
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewReplicated builds engine from primary and replicas, replicas are pinged
+// every healthInterval and unhealthy ones are skipped until they answer
+// again, zero interval means DefaultHealthInterval and negative one disables
+// health checks
+func NewReplicated(primary *common.DBMethods, replicas []*common.DBMethods, policy ReplicaPolicy, healthInterval time.Duration) common.Engine {
+	r := &replicated{
+		DBMethods: primary,
+		policy:    policy,
+		stop:      make(chan struct{}),
+	}
+	for _, d := range replicas {
+		node := &replica{DBMethods: d}
+		node.healthy.Store(true)
+		r.replicas = append(r.replicas, node)
+	}
+	if healthInterval == 0 {
+		healthInterval = DefaultHealthInterval
+	}
+	if healthInterval > 0 && len(r.replicas) > 0 {
+		r.wg.Add(1)
+		go r.monitor(healthInterval)
+	}
+	return r
+}
+
+func (r *replicated) monitor(interval time.Duration) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			for _, node := range r.replicas {
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				node.healthy.Store(node.DB.PingContext(ctx) == nil)
+				cancel()
+			}
+		}
+	}
+}
+
+// owner returns methods of node which started transaction of context or nil
+func (r *replicated) owner(ctx context.Context) *common.DBMethods {
+	if r.DBMethods.ContextTx(ctx) != nil {
+		return r.DBMethods
+	}
+	for _, node := range r.replicas {
+		if node.ContextTx(ctx) != nil {
+			return node.DBMethods
+		}
+	}
+	return nil
+}
+
+// reader returns methods of replica for read query or primary when it's
+// forced by context or when there are no healthy replicas, node of context
+// transaction is used to run query inside it
+func (r *replicated) reader(ctx context.Context) *common.DBMethods {
+	if d := r.owner(ctx); d != nil {
+		return d
+	}
+	if len(r.replicas) == 0 || isPrimary(ctx) {
+		return r.DBMethods
+	}
+
+	if r.policy == LeastConnections {
+		var best *replica
+		var inUse int
+		for _, node := range r.replicas {
+			if !node.healthy.Load() {
+				continue
+			}
+			if n := node.Stats().InUse; best == nil || n < inUse {
+				best, inUse = node, n
+			}
+		}
+		if best != nil {
+			return best.DBMethods
+		}
+		return r.DBMethods
+	}
+
+	start := r.next.Add(1) - 1
+	for i := 0; i < len(r.replicas); i++ {
+		node := r.replicas[(start+uint64(i))%uint64(len(r.replicas))]
+		if node.healthy.Load() {
+			return node.DBMethods
+		}
+	}
+	return r.DBMethods
+}
+
+// writer returns methods of primary for write query, node of context
+// transaction is used to run query inside it, so write inside read-only
+// transaction of replica fails instead of running outside of it
+func (r *replicated) writer(ctx context.Context) *common.DBMethods {
+	if d := r.owner(ctx); d != nil {
+		return d
+	}
+	return r.DBMethods
+}
+
+// Begin starts read-only transactions on replica
+func (r *replicated) Begin(ctx context.Context, opts *sql.TxOptions) (*common.Tx, error) {
+	if opts != nil && opts.ReadOnly {
+		return r.reader(ctx).Begin(ctx, opts)
+	}
+	return r.DBMethods.Begin(ctx, opts)
+}
+
+func (r *replicated) Close() error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+	errs := []error{r.DBMethods.Close()}
+	for _, node := range r.replicas {
+		errs = append(errs, node.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// ContextTx returns transaction stored in context when it was started by
+// primary or one of replicas
+func (r *replicated) ContextTx(ctx context.Context) *common.Tx {
+	if d := r.owner(ctx); d != nil {
+		return d.ContextTx(ctx)
+	}
+	return nil
+}
+
+func (r *replicated) DeleteRowByID(ctx context.Context, id int64, row any) error {
+	return r.writer(ctx).DeleteRowByID(ctx, id, row)
+}
+
+func (r *replicated) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return r.writer(ctx).Exec(ctx, query, args...)
+}
+
+func (r *replicated) ExecPrepared(ctx context.Context, prep *common.Prepared) (sql.Result, error) {
+	return r.writer(ctx).ExecPrepared(ctx, prep)
+}
+
+func (r *replicated) InsertRow(ctx context.Context, row any) error {
+	return r.writer(ctx).InsertRow(ctx, row)
+}
+
+func (r *replicated) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	return r.writer(ctx).NamedExec(ctx, query, arg)
+}
+
+func (r *replicated) Ping(ctx context.Context) error {
+	return r.writer(ctx).Ping(ctx)
+}
+
+func (r *replicated) Prepare(ctx context.Context, query string) (*common.Stmt, error) {
+	return r.writer(ctx).Prepare(ctx, query)
+}
+
+func (r *replicated) Shutdown(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+	errs := make([]error, len(r.replicas)+1)
+	var wg sync.WaitGroup
+	for i, d := range append([]*common.DBMethods{r.DBMethods}, r.methods()...) {
+		wg.Add(1)
+		go func(i int, d *common.DBMethods) {
+			defer wg.Done()
+			errs[i] = d.Shutdown(ctx)
+		}(i, d)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (r *replicated) methods() []*common.DBMethods {
+	methods := make([]*common.DBMethods, len(r.replicas))
+	for i, node := range r.replicas {
+		methods[i] = node.DBMethods
+	}
+	return methods
+}
+
+// Stats returns sum of connections pool statistics of primary and replicas
+func (r *replicated) Stats() sql.DBStats {
+	stats := []sql.DBStats{r.DBMethods.Stats()}
+	for _, node := range r.replicas {
+		stats = append(stats, node.Stats())
+	}
+	return sumStats(stats...)
+}
+
+func (r *replicated) Transaction(ctx context.Context, queries func(ctx context.Context, tx *common.Tx) error) error {
+	return r.writer(ctx).Transaction(ctx, queries)
+}
+
+func (r *replicated) TransactionRetry(ctx context.Context, policy common.RetryPolicy, queries func(ctx context.Context, tx *common.Tx) error) error {
+	return r.writer(ctx).TransactionRetry(ctx, policy, queries)
+}
+
+// TransactionWith runs read-only transactions on replica
+func (r *replicated) TransactionWith(ctx context.Context, opts *sql.TxOptions, queries func(ctx context.Context, tx *common.Tx) error) error {
+	if opts != nil && opts.ReadOnly {
+		return r.reader(ctx).TransactionWith(ctx, opts, queries)
+	}
+	return r.writer(ctx).TransactionWith(ctx, opts, queries)
+}
+
+func (r *replicated) UpdateRow(ctx context.Context, row any) error {
+	return r.writer(ctx).UpdateRow(ctx, row)
+}
+
+func (r *replicated) UpdateRowOnly(ctx context.Context, row any, fields ...string) error {
+	return r.writer(ctx).UpdateRowOnly(ctx, row, fields...)
+}
+
+func (r *replicated) Each(ctx context.Context, query string, callback func(ctx context.Context, rows *common.Rows) error, args ...any) error {
+	return r.reader(ctx).Each(ctx, query, callback, args...)
+}
+
+func (r *replicated) EachPrepared(ctx context.Context, prep *common.Prepared, callback func(ctx context.Context, rows *common.Rows) error) error {
+	return r.reader(ctx).EachPrepared(ctx, prep, callback)
+}
+
+func (r *replicated) NamedQuery(ctx context.Context, query string, arg any) (*common.Rows, error) {
+	return r.reader(ctx).NamedQuery(ctx, query, arg)
+}
+
+func (r *replicated) NamedQueryRow(ctx context.Context, query string, arg any) *common.Row {
+	return r.reader(ctx).NamedQueryRow(ctx, query, arg)
+}
+
+func (r *replicated) Paginate(ctx context.Context, query string, page common.Page, callback func(ctx context.Context, rows *common.Rows) error, args ...any) (*common.PageResult, error) {
+	return r.reader(ctx).Paginate(ctx, query, page, callback, args...)
+}
+
+func (r *replicated) Query(ctx context.Context, query string, args ...any) (*common.Rows, error) {
+	return r.reader(ctx).Query(ctx, query, args...)
+}
+
+func (r *replicated) QueryPrepared(ctx context.Context, prep *common.Prepared) (*common.Rows, error) {
+	return r.reader(ctx).QueryPrepared(ctx, prep)
+}
+
+func (r *replicated) QueryRow(ctx context.Context, query string, args ...any) *common.Row {
+	return r.reader(ctx).QueryRow(ctx, query, args...)
+}
+
+func (r *replicated) QueryRowByID(ctx context.Context, id int64, row any) error {
+	return r.reader(ctx).QueryRowByID(ctx, id, row)
+}
+
+func (r *replicated) QueryRowPrepared(ctx context.Context, prep *common.Prepared) *common.Row {
+	return r.reader(ctx).QueryRowPrepared(ctx, prep)
+}
+
+func (r *replicated) RowExists(ctx context.Context, id int64, row any) bool {
+	return r.reader(ctx).RowExists(ctx, id, row)
+}
+
+func (r *replicated) SetConnMaxLifetime(d time.Duration) {
+	r.DBMethods.SetConnMaxLifetime(d)
+	for _, node := range r.replicas {
+		node.SetConnMaxLifetime(d)
+	}
+}
+
+func (r *replicated) SetMaxIdleConns(n int) {
+	r.DBMethods.SetMaxIdleConns(n)
+	for _, node := range r.replicas {
+		node.SetMaxIdleConns(n)
+	}
+}
+
+func (r *replicated) SetMaxOpenConns(n int) {
+	r.DBMethods.SetMaxOpenConns(n)
+	for _, node := range r.replicas {
+		node.SetMaxOpenConns(n)
+	}
+}
@@ -0,0 +1,474 @@
+package engine
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/vladimirok5959/golang-sql/gosql/common"
+)
+
+// ShardFunc returns index of shard for call. Args are query args for query
+// methods, row (and id) for row methods, named arg for named methods and
+// empty for Begin, Transaction, Ping and Prepare
+type ShardFunc func(ctx context.Context, args ...any) (int, error)
+
+type shardKey struct{}
+
+// WithShardKey returns context with shard key for ShardFunc
+func WithShardKey(ctx context.Context, key any) context.Context {
+	return context.WithValue(ctx, shardKey{}, key)
+}
+
+// ShardKey returns shard key set by WithShardKey
+func ShardKey(ctx context.Context) (any, bool) {
+	key := ctx.Value(shardKey{})
+	return key, key != nil
+}
+
+// HashShardKey returns ShardFunc which picks one of count shards by FNV hash
+// of shard key from context
+func HashShardKey(count int) (ShardFunc, error) {
+	if count <= 0 {
+		return nil, fmt.Errorf("shard count must be positive: %d", count)
+	}
+	return func(ctx context.Context, args ...any) (int, error) {
+		key, ok := ShardKey(ctx)
+		if !ok {
+			return 0, fmt.Errorf("shard key is not set")
+		}
+		h := fnv.New32a()
+		_, _ = fmt.Fprint(h, key)
+		return int(h.Sum32() % uint32(count)), nil
+	}, nil
+}
+
+// ShardedEngine holds multiple engines and sends each call to one of them
+// chosen by ShardFunc
+type ShardedEngine struct {
+	shard  ShardFunc
+	shards []common.Engine
+}
+
+// NewSharded builds engine which routes calls to shards by fn
+func NewSharded(shards []common.Engine, fn ShardFunc) (*ShardedEngine, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("shards are not set")
+	}
+	for i, db := range shards {
+		if db == nil {
+			return nil, fmt.Errorf("shard is nil: %d", i)
+		}
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("shard func is not set")
+	}
+	return &ShardedEngine{
+		shard:  fn,
+		shards: shards,
+	}, nil
+}
+
+// txOwner is implemented by engines which tell whether transaction of context
+// was started by them
+type txOwner interface {
+	ContextTx(ctx context.Context) *common.Tx
+}
+
+// owner returns index of shard which started transaction of context or -1
+func (s *ShardedEngine) owner(ctx context.Context) int {
+	if common.TxFromContext(ctx) == nil {
+		return -1
+	}
+	for i, db := range s.shards {
+		if o, ok := db.(txOwner); ok && o.ContextTx(ctx) != nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// pick returns shard for call, inside transaction of context it's shard of
+// transaction and call routed to other shard fails, because it can't join
+// transaction
+func (s *ShardedEngine) pick(ctx context.Context, args ...any) (common.Engine, error) {
+	owner := s.owner(ctx)
+	if owner >= 0 && len(args) == 0 {
+		return s.shards[owner], nil
+	}
+	index, db, err := s.route(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	if owner >= 0 && index != owner {
+		return nil, fmt.Errorf("shard %d is outside of transaction of shard %d", index, owner)
+	}
+	return db, nil
+}
+
+// route returns shard chosen by ShardFunc
+func (s *ShardedEngine) route(ctx context.Context, args ...any) (int, common.Engine, error) {
+	index, err := s.shard(ctx, args...)
+	if err != nil {
+		return 0, nil, err
+	}
+	db, err := s.Shard(index)
+	return index, db, err
+}
+
+// ContextTx returns transaction stored in context when it was started by one
+// of shards
+func (s *ShardedEngine) ContextTx(ctx context.Context) *common.Tx {
+	if s.owner(ctx) < 0 {
+		return nil
+	}
+	return common.TxFromContext(ctx)
+}
+
+// Shard returns engine of shard by index
+func (s *ShardedEngine) Shard(index int) (common.Engine, error) {
+	if index < 0 || index >= len(s.shards) {
+		return nil, fmt.Errorf("shard index is out of range: %d", index)
+	}
+	return s.shards[index], nil
+}
+
+// Shards returns count of shards
+func (s *ShardedEngine) Shards() int {
+	return len(s.shards)
+}
+
+// EachShard calls fn for each shard concurrently and returns joined errors
+func (s *ShardedEngine) EachShard(ctx context.Context, fn func(ctx context.Context, index int, db common.Engine) error) error {
+	errs := make([]error, len(s.shards))
+	var wg sync.WaitGroup
+	for i, db := range s.shards {
+		wg.Add(1)
+		go func(i int, db common.Engine) {
+			defer wg.Done()
+			if err := fn(ctx, i, db); err != nil {
+				errs[i] = fmt.Errorf("shard %d: %w", i, err)
+			}
+		}(i, db)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// EachAll runs query on all shards concurrently, callback calls are
+// serialized, so results can be merged without locks
+func (s *ShardedEngine) EachAll(ctx context.Context, query string, callback func(ctx context.Context, rows *common.Rows) error, args ...any) error {
+	if callback == nil {
+		return fmt.Errorf("callback is not set")
+	}
+	var mu sync.Mutex
+	return s.EachShard(ctx, func(ctx context.Context, index int, db common.Engine) error {
+		return db.Each(ctx, query, func(ctx context.Context, rows *common.Rows) error {
+			mu.Lock()
+			defer mu.Unlock()
+			return callback(ctx, rows)
+		}, args...)
+	})
+}
+
+// ExecAll runs query on all shards concurrently and returns total count of
+// affected rows
+func (s *ShardedEngine) ExecAll(ctx context.Context, query string, args ...any) (int64, error) {
+	var mu sync.Mutex
+	var total int64
+	err := s.EachShard(ctx, func(ctx context.Context, index int, db common.Engine) error {
+		res, err := db.Exec(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		total += n
+		mu.Unlock()
+		return nil
+	})
+	return total, err
+}
+
+func (s *ShardedEngine) Begin(ctx context.Context, opts *sql.TxOptions) (*common.Tx, error) {
+	db, err := s.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.Begin(ctx, opts)
+}
+
+func (s *ShardedEngine) Close() error {
+	var errs []error
+	for _, db := range s.shards {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+func (s *ShardedEngine) CurrentUnixTimestamp() int64 {
+	return s.shards[0].CurrentUnixTimestamp()
+}
+
+func (s *ShardedEngine) DeleteRowByID(ctx context.Context, id int64, row any) error {
+	db, err := s.pick(ctx, id, row)
+	if err != nil {
+		return err
+	}
+	return db.DeleteRowByID(ctx, id, row)
+}
+
+func (s *ShardedEngine) Each(ctx context.Context, query string, callback func(ctx context.Context, rows *common.Rows) error, args ...any) error {
+	db, err := s.pick(ctx, args...)
+	if err != nil {
+		return err
+	}
+	return db.Each(ctx, query, callback, args...)
+}
+
+func (s *ShardedEngine) EachPrepared(ctx context.Context, prep *common.Prepared, callback func(ctx context.Context, rows *common.Rows) error) error {
+	db, err := s.pick(ctx, prep.Args...)
+	if err != nil {
+		return err
+	}
+	return db.EachPrepared(ctx, prep, callback)
+}
+
+func (s *ShardedEngine) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	db, err := s.pick(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return db.Exec(ctx, query, args...)
+}
+
+func (s *ShardedEngine) ExecPrepared(ctx context.Context, prep *common.Prepared) (sql.Result, error) {
+	db, err := s.pick(ctx, prep.Args...)
+	if err != nil {
+		return nil, err
+	}
+	return db.ExecPrepared(ctx, prep)
+}
+
+func (s *ShardedEngine) InsertRow(ctx context.Context, row any) error {
+	db, err := s.pick(ctx, row)
+	if err != nil {
+		return err
+	}
+	return db.InsertRow(ctx, row)
+}
+
+func (s *ShardedEngine) Lock(ctx context.Context, name string) error {
+	_, db, err := s.route(ctx, name)
+	if err != nil {
+		return err
+	}
+	return db.Lock(ctx, name)
+}
+
+func (s *ShardedEngine) LockLost(ctx context.Context, name string) <-chan struct{} {
+	_, db, err := s.route(ctx, name)
+	if err != nil {
+		lost := make(chan struct{})
+		close(lost)
+		return lost
+	}
+	return db.LockLost(ctx, name)
+}
+
+func (s *ShardedEngine) NamedExec(ctx context.Context, query string, arg any) (sql.Result, error) {
+	db, err := s.pick(ctx, arg)
+	if err != nil {
+		return nil, err
+	}
+	return db.NamedExec(ctx, query, arg)
+}
+
+func (s *ShardedEngine) NamedQuery(ctx context.Context, query string, arg any) (*common.Rows, error) {
+	db, err := s.pick(ctx, arg)
+	if err != nil {
+		return &common.Rows{}, err
+	}
+	return db.NamedQuery(ctx, query, arg)
+}
+
+func (s *ShardedEngine) NamedQueryRow(ctx context.Context, query string, arg any) *common.Row {
+	db, err := s.pick(ctx, arg)
+	if err != nil {
+		return common.ErrorRow(err)
+	}
+	return db.NamedQueryRow(ctx, query, arg)
+}
+
+func (s *ShardedEngine) Paginate(ctx context.Context, query string, page common.Page, callback func(ctx context.Context, rows *common.Rows) error, args ...any) (*common.PageResult, error) {
+	db, err := s.pick(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	return db.Paginate(ctx, query, page, callback, args...)
+}
+
+func (s *ShardedEngine) Ping(ctx context.Context) error {
+	db, err := s.pick(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Ping(ctx)
+}
+
+func (s *ShardedEngine) Prepare(ctx context.Context, query string) (*common.Stmt, error) {
+	db, err := s.pick(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return db.Prepare(ctx, query)
+}
+
+func (s *ShardedEngine) PrepareSQL(query string, args ...any) *common.Prepared {
+	return s.shards[0].PrepareSQL(query, args...)
+}
+
+func (s *ShardedEngine) Query(ctx context.Context, query string, args ...any) (*common.Rows, error) {
+	db, err := s.pick(ctx, args...)
+	if err != nil {
+		return &common.Rows{}, err
+	}
+	return db.Query(ctx, query, args...)
+}
+
+func (s *ShardedEngine) QueryPrepared(ctx context.Context, prep *common.Prepared) (*common.Rows, error) {
+	db, err := s.pick(ctx, prep.Args...)
+	if err != nil {
+		return &common.Rows{}, err
+	}
+	return db.QueryPrepared(ctx, prep)
+}
+
+func (s *ShardedEngine) QueryRow(ctx context.Context, query string, args ...any) *common.Row {
+	db, err := s.pick(ctx, args...)
+	if err != nil {
+		return common.ErrorRow(err)
+	}
+	return db.QueryRow(ctx, query, args...)
+}
+
+func (s *ShardedEngine) QueryRowByID(ctx context.Context, id int64, row any) error {
+	db, err := s.pick(ctx, id, row)
+	if err != nil {
+		return err
+	}
+	return db.QueryRowByID(ctx, id, row)
+}
+
+func (s *ShardedEngine) QueryRowPrepared(ctx context.Context, prep *common.Prepared) *common.Row {
+	db, err := s.pick(ctx, prep.Args...)
+	if err != nil {
+		return common.ErrorRow(err)
+	}
+	return db.QueryRowPrepared(ctx, prep)
+}
+
+func (s *ShardedEngine) RowExists(ctx context.Context, id int64, row any) bool {
+	db, err := s.pick(ctx, id, row)
+	if err != nil {
+		return false
+	}
+	return db.RowExists(ctx, id, row)
+}
+
+func (s *ShardedEngine) SetConnMaxLifetime(d time.Duration) {
+	for _, db := range s.shards {
+		db.SetConnMaxLifetime(d)
+	}
+}
+
+func (s *ShardedEngine) SetMaxIdleConns(n int) {
+	for _, db := range s.shards {
+		db.SetMaxIdleConns(n)
+	}
+}
+
+func (s *ShardedEngine) SetMaxOpenConns(n int) {
+	for _, db := range s.shards {
+		db.SetMaxOpenConns(n)
+	}
+}
+
+// Shutdown shuts down all shards concurrently
+func (s *ShardedEngine) Shutdown(ctx context.Context) error {
+	return s.EachShard(ctx, func(ctx context.Context, index int, db common.Engine) error {
+		return db.Shutdown(ctx)
+	})
+}
+
+// Stats returns sum of connections pool statistics of all shards
+func (s *ShardedEngine) Stats() sql.DBStats {
+	stats := make([]sql.DBStats, len(s.shards))
+	for i, db := range s.shards {
+		stats[i] = db.Stats()
+	}
+	return sumStats(stats...)
+}
+
+func (s *ShardedEngine) Transaction(ctx context.Context, queries func(ctx context.Context, tx *common.Tx) error) error {
+	db, err := s.pick(ctx)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(ctx, queries)
+}
+
+func (s *ShardedEngine) TransactionRetry(ctx context.Context, policy common.RetryPolicy, queries func(ctx context.Context, tx *common.Tx) error) error {
+	db, err := s.pick(ctx)
+	if err != nil {
+		return err
+	}
+	return db.TransactionRetry(ctx, policy, queries)
+}
+
+func (s *ShardedEngine) TransactionWith(ctx context.Context, opts *sql.TxOptions, queries func(ctx context.Context, tx *common.Tx) error) error {
+	db, err := s.pick(ctx)
+	if err != nil {
+		return err
+	}
+	return db.TransactionWith(ctx, opts, queries)
+}
+
+func (s *ShardedEngine) TryLock(ctx context.Context, name string) (bool, error) {
+	_, db, err := s.route(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	return db.TryLock(ctx, name)
+}
+
+func (s *ShardedEngine) Unlock(ctx context.Context, name string) error {
+	_, db, err := s.route(ctx, name)
+	if err != nil {
+		return err
+	}
+	return db.Unlock(ctx, name)
+}
+
+func (s *ShardedEngine) UpdateRow(ctx context.Context, row any) error {
+	db, err := s.pick(ctx, row)
+	if err != nil {
+		return err
+	}
+	return db.UpdateRow(ctx, row)
+}
+
+func (s *ShardedEngine) UpdateRowOnly(ctx context.Context, row any, fields ...string) error {
+	db, err := s.pick(ctx, row)
+	if err != nil {
+		return err
+	}
+	return db.UpdateRowOnly(ctx, row, fields...)
+}
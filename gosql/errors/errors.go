@@ -0,0 +1,166 @@
+// Package errors classifies errors of MySQL, PostgreSQL and SQLite drivers,
+// so callers don't need to import driver packages
+package errors
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+var rMySQLKey = regexp.MustCompile("for key '([^']+)'")
+var rMySQLConstraint = regexp.MustCompile("CONSTRAINT `([^`]+)`")
+var rSQLiteConstraint = regexp.MustCompile(`constraint failed: (.+)$`)
+
+func mysqlError(err error) (*mysql.MySQLError, bool) {
+	var e *mysql.MySQLError
+	return e, errors.As(err, &e)
+}
+
+func pqError(err error) (*pq.Error, bool) {
+	var e *pq.Error
+	return e, errors.As(err, &e)
+}
+
+func sqliteError(err error) (sqlite3.Error, bool) {
+	var e sqlite3.Error
+	return e, errors.As(err, &e)
+}
+
+// Code returns driver error code: SQLSTATE for PostgreSQL, error number for
+// MySQL and extended result code for SQLite, empty string for other errors
+func Code(err error) string {
+	if e, ok := pqError(err); ok {
+		return string(e.Code)
+	}
+	if e, ok := mysqlError(err); ok {
+		return strconv.Itoa(int(e.Number))
+	}
+	if e, ok := sqliteError(err); ok {
+		return strconv.Itoa(int(e.ExtendedCode))
+	}
+	return ""
+}
+
+// Constraint returns name of violated constraint. For MySQL it's parsed from
+// error message, for SQLite it's list of columns like "users.email" because
+// SQLite doesn't report constraint names. Empty string is returned when name
+// is not known
+func Constraint(err error) string {
+	if e, ok := pqError(err); ok {
+		return e.Constraint
+	}
+	if e, ok := mysqlError(err); ok {
+		if m := rMySQLKey.FindStringSubmatch(e.Message); m != nil {
+			return m[1]
+		}
+		if m := rMySQLConstraint.FindStringSubmatch(e.Message); m != nil {
+			return m[1]
+		}
+		return ""
+	}
+	if e, ok := sqliteError(err); ok && e.Code == sqlite3.ErrConstraint {
+		if m := rSQLiteConstraint.FindStringSubmatch(e.Error()); m != nil {
+			return m[1]
+		}
+	}
+	return ""
+}
+
+// IsConnectionError reports whether err is caused by broken or refused
+// connection, timeouts and cancellations of context are not connection errors
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, mysql.ErrInvalidConn) || errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	if e, ok := pqError(err); ok {
+		// Class 08 - Connection Exception
+		return strings.HasPrefix(string(e.Code), "08")
+	}
+	if e, ok := sqliteError(err); ok {
+		return e.Code == sqlite3.ErrCantOpen
+	}
+	return false
+}
+
+// IsDeadlock reports whether transaction is aborted by deadlock, for SQLite
+// it's SQLITE_BUSY and SQLITE_LOCKED
+func IsDeadlock(err error) bool {
+	if e, ok := pqError(err); ok {
+		return e.Code == "40P01"
+	}
+	if e, ok := mysqlError(err); ok {
+		return e.Number == 1213
+	}
+	if e, ok := sqliteError(err); ok {
+		return e.Code == sqlite3.ErrBusy || e.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
+// IsForeignKeyViolation reports whether foreign key constraint is violated
+func IsForeignKeyViolation(err error) bool {
+	if e, ok := pqError(err); ok {
+		return e.Code == "23503"
+	}
+	if e, ok := mysqlError(err); ok {
+		return e.Number == 1216 || e.Number == 1217 || e.Number == 1451 || e.Number == 1452
+	}
+	if e, ok := sqliteError(err); ok {
+		return e.ExtendedCode == sqlite3.ErrConstraintForeignKey
+	}
+	return false
+}
+
+// IsNotFound reports whether query returned no rows
+func IsNotFound(err error) bool {
+	return errors.Is(err, sql.ErrNoRows)
+}
+
+// IsNotNullViolation reports whether NULL is set to NOT NULL column
+func IsNotNullViolation(err error) bool {
+	if e, ok := pqError(err); ok {
+		return e.Code == "23502"
+	}
+	if e, ok := mysqlError(err); ok {
+		return e.Number == 1048 || e.Number == 1364
+	}
+	if e, ok := sqliteError(err); ok {
+		return e.ExtendedCode == sqlite3.ErrConstraintNotNull
+	}
+	return false
+}
+
+// IsUniqueViolation reports whether unique or primary key constraint is
+// violated
+func IsUniqueViolation(err error) bool {
+	if e, ok := pqError(err); ok {
+		return e.Code == "23505"
+	}
+	if e, ok := mysqlError(err); ok {
+		return e.Number == 1062 || e.Number == 1586
+	}
+	if e, ok := sqliteError(err); ok {
+		return e.ExtendedCode == sqlite3.ErrConstraintUnique || e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
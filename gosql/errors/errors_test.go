@@ -0,0 +1,133 @@
+package errors_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vladimirok5959/golang-sql/gosql"
+	"github.com/vladimirok5959/golang-sql/gosql/common"
+	"github.com/vladimirok5959/golang-sql/gosql/errors"
+)
+
+var _ = Describe("errors", func() {
+	Context("for MySQL", func() {
+		It("classify errors", func() {
+			err := fmt.Errorf("insert: %w", &mysql.MySQLError{
+				Number:  1062,
+				Message: "Duplicate entry 'alice@example.com' for key 'users.users_email_uniq'",
+			})
+			Expect(errors.IsUniqueViolation(err)).To(BeTrue())
+			Expect(errors.IsForeignKeyViolation(err)).To(BeFalse())
+			Expect(errors.Constraint(err)).To(Equal("users.users_email_uniq"))
+			Expect(errors.Code(err)).To(Equal("1062"))
+
+			err = &mysql.MySQLError{
+				Number:  1452,
+				Message: "Cannot add or update a child row: a foreign key constraint fails (`gosql`.`posts`, CONSTRAINT `posts_user_fk` FOREIGN KEY (`user_id`) REFERENCES `users` (`id`))",
+			}
+			Expect(errors.IsForeignKeyViolation(err)).To(BeTrue())
+			Expect(errors.Constraint(err)).To(Equal("posts_user_fk"))
+
+			Expect(errors.IsNotNullViolation(&mysql.MySQLError{Number: 1048})).To(BeTrue())
+			Expect(errors.IsDeadlock(&mysql.MySQLError{Number: 1213})).To(BeTrue())
+			Expect(errors.IsConnectionError(mysql.ErrInvalidConn)).To(BeTrue())
+		})
+	})
+
+	Context("for PostgreSQL", func() {
+		It("classify errors", func() {
+			err := fmt.Errorf("insert: %w", &pq.Error{Code: "23505", Constraint: "users_email_uniq"})
+			Expect(errors.IsUniqueViolation(err)).To(BeTrue())
+			Expect(errors.IsNotNullViolation(err)).To(BeFalse())
+			Expect(errors.Constraint(err)).To(Equal("users_email_uniq"))
+			Expect(errors.Code(err)).To(Equal("23505"))
+
+			Expect(errors.IsForeignKeyViolation(&pq.Error{Code: "23503"})).To(BeTrue())
+			Expect(errors.IsNotNullViolation(&pq.Error{Code: "23502"})).To(BeTrue())
+			Expect(errors.IsDeadlock(&pq.Error{Code: "40P01"})).To(BeTrue())
+			Expect(errors.IsDeadlock(&pq.Error{Code: "40001"})).To(BeFalse())
+			Expect(errors.IsConnectionError(&pq.Error{Code: "08006"})).To(BeTrue())
+		})
+	})
+
+	Context("for SQLite", func() {
+		var ctx = context.Background()
+		var db common.Engine
+
+		BeforeEach(func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err = gosql.Open("sqlite://"+f.Name()+"?_foreign_keys=1", "", true, false)
+			Expect(err).To(Succeed())
+
+			_, err = db.Exec(ctx, `
+				create table users (id integer primary key, email varchar(255) not null unique);
+				create table posts (id integer primary key, user_id integer references users (id));
+				insert into users (id, email) values (1, 'alice@example.com');
+			`)
+			Expect(err).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("classify errors", func() {
+			_, err := db.Exec(ctx, "insert into users (id, email) values (2, 'alice@example.com')")
+			Expect(errors.IsUniqueViolation(err)).To(BeTrue())
+			Expect(errors.IsNotNullViolation(err)).To(BeFalse())
+			Expect(errors.Constraint(err)).To(Equal("users.email"))
+			Expect(errors.Code(err)).To(Equal("2067"))
+
+			_, err = db.Exec(ctx, "insert into users (id, email) values (1, 'bob@example.com')")
+			Expect(errors.IsUniqueViolation(err)).To(BeTrue())
+
+			_, err = db.Exec(ctx, "insert into users (id, email) values (3, null)")
+			Expect(errors.IsNotNullViolation(err)).To(BeTrue())
+			Expect(errors.Constraint(err)).To(Equal("users.email"))
+
+			_, err = db.Exec(ctx, "insert into posts (id, user_id) values (1, 100)")
+			Expect(errors.IsForeignKeyViolation(err)).To(BeTrue())
+			Expect(errors.IsUniqueViolation(err)).To(BeFalse())
+
+			var id int
+			err = db.QueryRow(ctx, "select id from users where id=$1", 100).Scan(&id)
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+	})
+
+	It("classify common errors", func() {
+		Expect(errors.IsNotFound(fmt.Errorf("user: %w", sql.ErrNoRows))).To(BeTrue())
+		Expect(errors.IsConnectionError(driver.ErrBadConn)).To(BeTrue())
+		Expect(errors.IsConnectionError(&net.OpError{Op: "dial", Err: fmt.Errorf("refused")})).To(BeTrue())
+		Expect(errors.IsConnectionError(fmt.Errorf("query: %w", context.DeadlineExceeded))).To(BeFalse())
+		Expect(errors.IsConnectionError(context.Canceled)).To(BeFalse())
+
+		for _, err := range []error{nil, fmt.Errorf("example")} {
+			Expect(errors.IsConnectionError(err)).To(BeFalse())
+			Expect(errors.IsDeadlock(err)).To(BeFalse())
+			Expect(errors.IsForeignKeyViolation(err)).To(BeFalse())
+			Expect(errors.IsNotFound(err)).To(BeFalse())
+			Expect(errors.IsNotNullViolation(err)).To(BeFalse())
+			Expect(errors.IsUniqueViolation(err)).To(BeFalse())
+			Expect(errors.Constraint(err)).To(Equal(""))
+			Expect(errors.Code(err)).To(Equal(""))
+		}
+	})
+})
+
+func TestSuite(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "gosql/errors")
+}
@@ -1,32 +1,271 @@
 package gosql
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
+	"os"
+	"slices"
 
 	"github.com/vladimirok5959/golang-sql/gosql/common"
 	"github.com/vladimirok5959/golang-sql/gosql/engine"
 )
 
+// ErrShutdown is returned by engine methods which are called after Shutdown
+var ErrShutdown = common.ErrShutdown
+
+type Health = common.Health
+
+type HealthConfig = common.HealthConfig
+
+type HealthMonitor = common.HealthMonitor
+
+type HealthState = common.HealthState
+
+const (
+	HealthUnknown = common.HealthUnknown
+	Healthy       = common.Healthy
+	Unhealthy     = common.Unhealthy
+)
+
+type Hook = common.Hook
+
+type Metrics = common.Metrics
+
+type Page = common.Page
+
+type PageResult = common.PageResult
+
+type Pinger = common.Pinger
+
+type QueryEvent = common.QueryEvent
+
+type QueryError = common.QueryError
+
+type ReplicaPolicy = engine.ReplicaPolicy
+
+const (
+	LeastConnections = engine.LeastConnections
+	RoundRobin       = engine.RoundRobin
+)
+
+const DefaultHealthInterval = engine.DefaultHealthInterval
+
+type RetryPolicy = common.RetryPolicy
+
 type Row = common.Row
 
 type Rows = common.Rows
 
+type ShardFunc = engine.ShardFunc
+
+type ShardedEngine = engine.ShardedEngine
+
+type Stmt = common.Stmt
+
 type Tx = common.Tx
 
+type TxOptions = sql.TxOptions
+
+const (
+	LevelDefault         = sql.LevelDefault
+	LevelReadUncommitted = sql.LevelReadUncommitted
+	LevelReadCommitted   = sql.LevelReadCommitted
+	LevelRepeatableRead  = sql.LevelRepeatableRead
+	LevelSerializable    = sql.LevelSerializable
+)
+
+// RegisterEngine makes Open able to use third-party database/sql driver for
+// given URL scheme, dialect is used for SQL generation and for choosing
+// dbmate driver for migrations
+func RegisterEngine(scheme string, factory common.EngineFactory, dialect common.Dialect) {
+	common.RegisterEngine(scheme, factory, dialect)
+}
+
 func Open(dbURL, migrationsDir string, skipMigration bool, debug bool) (common.Engine, error) {
-	databaseURL, err := common.ParseUrl(dbURL)
+	return OpenWith(
+		context.Background(),
+		dbURL,
+		WithDebug(debug),
+		WithMigrations(migrationsDir),
+		WithSkipMigration(skipMigration),
+	)
+}
+
+// OpenWith opens database by URL, applies migrations and configures engine by
+// options. Migrations are applied from default dbmate directory unless other
+// source is set or WithSkipMigration is used. URL params with "gosql_" prefix
+// are applied after options, so they override ones set in code
+func OpenWith(ctx context.Context, dbURL string, opts ...Option) (common.Engine, error) {
+	d, _, err := openMethods(ctx, dbURL, opts, false)
 	if err != nil {
 		return nil, err
 	}
+	return engine.New(d), nil
+}
 
-	switch databaseURL.Scheme {
-	case "mysql":
-		return engine.NewMySQL(databaseURL, migrationsDir, skipMigration, debug)
-	case "postgres", "postgresql":
-		return engine.NewPostgreSQL(databaseURL, migrationsDir, skipMigration, debug)
-	case "sqlite", "sqlite3":
-		return engine.NewSQLite(databaseURL, migrationsDir, skipMigration, debug)
-	default:
-		return nil, fmt.Errorf("DB open error")
+// OpenReplicated opens primary and replicas by URLs with same options, write
+// queries and transactions go to primary, read queries go to replicas chosen
+// by WithReplicaPolicy. Migrations are applied to primary only. Use
+// UsePrimary for reading from primary
+func OpenReplicated(ctx context.Context, primaryURL string, replicaURLs []string, opts ...Option) (common.Engine, error) {
+	primary, o, err := openMethods(ctx, primaryURL, opts, false)
+	if err != nil {
+		return nil, err
 	}
+
+	var replicas []*common.DBMethods
+	for _, replicaURL := range replicaURLs {
+		d, _, err := openMethods(ctx, replicaURL, opts, true)
+		if err != nil {
+			_ = primary.Close()
+			for _, replica := range replicas {
+				_ = replica.Close()
+			}
+			return nil, err
+		}
+		replicas = append(replicas, d)
+	}
+
+	return engine.NewReplicated(primary, replicas, o.replicaPolicy, o.healthInterval), nil
+}
+
+// NewHealthMonitor returns monitor which pings db in background after Start
+// and calls cfg.OnChange when health state is changed
+func NewHealthMonitor(db Pinger, cfg HealthConfig) *HealthMonitor {
+	return common.NewHealthMonitor(db, cfg)
+}
+
+// NewMetrics returns metrics collector for WithMetrics option, use its Handler
+// for exposing metrics in Prometheus text format
+func NewMetrics(buckets ...float64) *Metrics {
+	return common.NewMetrics(buckets...)
+}
+
+// NewSharded builds engine which sends each call to one of shards chosen by
+// fn, use HashShardKey and WithShardKey for sharding by key from context
+func NewSharded(shards []common.Engine, fn ShardFunc) (*ShardedEngine, error) {
+	return engine.NewSharded(shards, fn)
+}
+
+// HashShardKey returns ShardFunc which picks one of count shards by hash of
+// key set by WithShardKey
+func HashShardKey(count int) (ShardFunc, error) {
+	return engine.HashShardKey(count)
+}
+
+// WithShardKey returns context with shard key for ShardFunc
+func WithShardKey(ctx context.Context, key any) context.Context {
+	return engine.WithShardKey(ctx, key)
+}
+
+// UsePrimary returns context which makes engine opened by OpenReplicated to
+// send read queries to primary
+func UsePrimary(ctx context.Context) context.Context {
+	return engine.WithPrimary(ctx)
+}
+
+// WithTx returns context which makes engine methods to run queries inside tx
+func WithTx(ctx context.Context, tx *Tx) context.Context {
+	return common.ContextWithTx(ctx, tx)
+}
+
+// TxFromContext returns transaction stored in context by Transaction or
+// WithTx, it returns nil outside of transaction
+func TxFromContext(ctx context.Context) *Tx {
+	return common.TxFromContext(ctx)
+}
+
+func openMethods(ctx context.Context, dbURL string, opts []Option, replica bool) (*common.DBMethods, *options, error) {
+	databaseURL, params, err := common.ParseUrlParams(dbURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	paramOpts, err := paramOptions(params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var o options
+	// URL params override options, opts of caller are not modified
+	for _, opt := range slices.Concat(opts, paramOpts) {
+		opt(&o)
+	}
+
+	if replica {
+		o.skipMigration = true
+	}
+
+	factory, dialect, ok := common.LookupEngine(databaseURL.Scheme)
+	if !ok {
+		if dialect, err = engine.DialectByName(databaseURL.Scheme); err != nil {
+			return nil, nil, fmt.Errorf("DB open error")
+		}
+	}
+
+	db, err := common.OpenDBContext(ctx, databaseURL, common.OpenConfig{
+		Debug:            o.debug,
+		Dialect:          dialect,
+		Factory:          factory,
+		Logger:           o.logger,
+		MigrationsDir:    o.migrationsDir,
+		MigrationsFS:     o.migrationsFS,
+		SkipMigration:    o.skipMigration,
+		ConnectTimeout:   o.connectTimeout,
+		RetryInterval:    o.retryInterval,
+		RetryMaxInterval: o.retryMax,
+		RetryMaxAttempts: o.retryAttempts,
+		ConnMaxIdleTime:  o.connMaxIdleTime,
+		ConnMaxLifetime:  o.connMaxLifetime,
+		MaxIdleConns:     o.maxIdleConns,
+		MaxOpenConns:     o.maxOpenConns,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return o.methods(db, dialect, databaseURL.Scheme), &o, nil
+}
+
+// Wrap builds engine around existing connections pool, dialect is URL scheme
+// of built-in or registered engine. Migrations are applied only when set by
+// options, each migration is sent as one query, so MySQL db must be opened
+// with multiStatements=true for migrations with several statements. Note:
+// Close of engine closes db too
+func Wrap(db *sql.DB, dialect string, opts ...Option) (common.Engine, error) {
+	d, err := engine.DialectByName(dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if o.migrationsFS == nil && o.migrationsDir != "" {
+		o.migrationsFS = os.DirFS(o.migrationsDir)
+	}
+
+	if o.migrationsFS != nil && !o.skipMigration {
+		if err := common.Migrate(db, d, o.migrationsFS); err != nil {
+			return nil, err
+		}
+	}
+
+	if o.maxOpenConns > 0 {
+		db.SetMaxOpenConns(o.maxOpenConns)
+	}
+	if o.maxIdleConns > 0 {
+		db.SetMaxIdleConns(o.maxIdleConns)
+	}
+	if o.connMaxLifetime > 0 {
+		db.SetConnMaxLifetime(o.connMaxLifetime)
+	}
+	if o.connMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(o.connMaxIdleTime)
+	}
+
+	return engine.New(o.methods(db, d, d.Name())), nil
 }
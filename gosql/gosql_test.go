@@ -1,14 +1,24 @@
 package gosql_test
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/vladimirok5959/golang-sql/gosql"
+	"github.com/vladimirok5959/golang-sql/gosql/engine"
 )
 
 var _ = Describe("gosql", func() {
@@ -512,6 +522,1025 @@ var _ = Describe("gosql", func() {
 			})
 		})
 
+		It("open connection, migrate and use named params", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			var rowUser struct {
+				ID   int64  `field:"id" table:"users"`
+				Name string `field:"name"`
+			}
+
+			rowUser.ID = 3
+			rowUser.Name = "James"
+			_, err = db.NamedExec(ctx, "INSERT INTO users (id, name) VALUES (:id, :name)", &rowUser)
+			Expect(err).To(Succeed())
+
+			err = db.NamedQueryRow(ctx, "SELECT id, name FROM users WHERE id=:id", map[string]any{"id": 3}).Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(id).To(Equal(3))
+			Expect(name).To(Equal("James"))
+
+			err = db.NamedQueryRow(ctx, "SELECT id, name FROM users WHERE id=:id", map[string]any{}).Scan(&id, &name)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("named parameter is not set: id"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and select with params out of order", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			err = db.QueryRow(ctx, "select id, name from users where name=$2 and id=$1", 2, "Bob").Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(id).To(Equal(2))
+			Expect(name).To(Equal("Bob"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and paginate", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			_, err = db.Exec(ctx, "INSERT INTO users (id, name) VALUES (3, 'James'), (4, 'Robert'), (5, 'Patrik')")
+			Expect(err).To(Succeed())
+
+			var ids []int64
+			collect := func(ctx context.Context, rows *gosql.Rows) error {
+				var id int64
+				var name string
+				if err := rows.Scan(&id, &name); err != nil {
+					return err
+				}
+				ids = append(ids, id)
+				return nil
+			}
+
+			res, err := db.Paginate(ctx, "SELECT id, name FROM users ORDER BY id ASC", gosql.Page{Limit: 2, Offset: 2, Count: true}, collect)
+			Expect(err).To(Succeed())
+			Expect(ids).To(Equal([]int64{3, 4}))
+			Expect(res.HasMore).To(BeTrue())
+			Expect(res.Total).To(Equal(int64(5)))
+
+			ids = nil
+			page := gosql.Page{Limit: 2, KeyColumn: "id"}
+			for {
+				res, err = db.Paginate(ctx, "SELECT id, name FROM users WHERE id > $1", page, collect, 1)
+				Expect(err).To(Succeed())
+				if !res.HasMore {
+					break
+				}
+				page.Cursor = res.NextCursor
+			}
+			Expect(ids).To(Equal([]int64{2, 3, 4, 5}))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection with registered engine, migrate and select data", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			gosql.RegisterEngine("sqlite-custom", openSQLite, engine.SQLiteDialect{})
+
+			db, err := gosql.Open("sqlite-custom://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			var rowUser struct {
+				ID   int64  `field:"id" table:"users"`
+				Name string `field:"name"`
+			}
+
+			err = db.QueryRowByID(ctx, 2, &rowUser)
+			Expect(err).To(Succeed())
+			Expect(rowUser.ID).To(Equal(int64(2)))
+			Expect(rowUser.Name).To(Equal("Bob"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection with options, migrate and select data", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+			var events []gosql.QueryEvent
+
+			db, err := gosql.OpenWith(
+				ctx,
+				"sqlite://"+f.Name(),
+				gosql.WithMigrations(migrationsDir),
+				gosql.WithDebug(true),
+				gosql.WithLogger(&buf),
+				gosql.WithHook(func(ctx context.Context, event gosql.QueryEvent) {
+					events = append(events, event)
+				}),
+				gosql.WithMaxOpenConns(1),
+				gosql.WithConnMaxLifetime(time.Minute),
+				gosql.WithConnectTimeout(time.Second),
+				gosql.WithQueryTimeout(time.Second),
+			)
+			Expect(err).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("[func Open]"))
+
+			err = db.QueryRow(ctx, sql, 2).Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(id).To(Equal(2))
+			Expect(name).To(Equal("Bob"))
+			Expect(buf.String()).To(ContainSubstring("[func QueryRow]"))
+
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Func).To(Equal("QueryRow"))
+			Expect(events[0].Query).To(Equal("select id, name from users where id=?1"))
+			Expect(events[0].Args).To(Equal([]any{2}))
+			Expect(events[0].Tx).To(BeFalse())
+			Expect(events[0].Err).To(BeNil())
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				time.Sleep(200 * time.Millisecond)
+				return tx.QueryRow(ctx, sql, 1).Scan(&id, &name)
+			})
+			Expect(err).To(Succeed())
+			Expect(name).To(Equal("Alice"))
+			Expect(events).To(HaveLen(4))
+			Expect(events[2].Func).To(Equal("QueryRow"))
+			Expect(events[2].Tx).To(BeTrue())
+			Expect(events[3].Func).To(Equal("Commit"))
+			Expect(events[3].Duration).To(BeNumerically("<", 200*time.Millisecond))
+
+			// Result of QueryRow is reported by Scan
+			Expect(db.QueryRow(ctx, sql, 100).Scan(&id, &name)).NotTo(Succeed())
+			Expect(events).To(HaveLen(5))
+			Expect(events[4].Func).To(Equal("QueryRow"))
+			Expect(events[4].Err.Error()).To(Equal("sql: no rows in result set"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection with URL params, migrate and select data", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			db, err := gosql.OpenWith(
+				ctx,
+				"sqlite://"+f.Name()+"?gosql_debug=true&gosql_max_open_conns=1&gosql_query_timeout=1s&gosql_migrations="+url.QueryEscape(migrationsDir),
+				gosql.WithDebug(false),
+				gosql.WithLogger(&buf),
+			)
+			Expect(err).To(Succeed())
+
+			err = db.QueryRow(ctx, sql, 2).Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(id).To(Equal(2))
+			Expect(name).To(Equal("Bob"))
+			Expect(buf.String()).To(ContainSubstring("[func QueryRow]"))
+
+			Expect(db.Close()).To(Succeed())
+
+			_, err = gosql.OpenWith(ctx, "sqlite://"+f.Name()+"?gosql_max_open_conns=many")
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal(`invalid URL param gosql_max_open_conns: strconv.Atoi: parsing "many": invalid syntax`))
+
+			_, err = gosql.OpenWith(ctx, "sqlite://"+f.Name()+"?gosql_example=1")
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("unsupported URL param: gosql_example"))
+
+			// Options of URL params must not be written to spare capacity of opts
+			opts := make([]gosql.Option, 1, 2)
+			opts[0] = gosql.WithMigrations(migrationsDir)
+			db, err = gosql.OpenWith(ctx, "sqlite://"+f.Name()+"?gosql_debug=false", opts...)
+			Expect(err).To(Succeed())
+			Expect(opts[:2][1]).To(BeNil())
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection with retry, migrate and select data", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			gosql.RegisterEngine("sqlite-flaky", flakySQLite(2), engine.SQLiteDialect{})
+
+			db, err := gosql.OpenWith(
+				ctx,
+				"sqlite-flaky://"+f.Name(),
+				gosql.WithMigrations(migrationsDir),
+				gosql.WithDebug(true),
+				gosql.WithLogger(&buf),
+				gosql.WithRetry(time.Millisecond, 2*time.Millisecond, 0),
+			)
+			Expect(err).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring("[func OpenRetry] (empty) (attempt 1 failed, retry in 1ms: DB open error: not ready: connection refused)"))
+			Expect(buf.String()).To(ContainSubstring("[func OpenRetry] (empty) (attempt 2 failed, retry in 2ms: DB open error: not ready: connection refused)"))
+			Expect(buf.String()).NotTo(ContainSubstring("attempt 3"))
+
+			err = db.QueryRow(ctx, sql, 2).Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(name).To(Equal("Bob"))
+
+			Expect(db.Close()).To(Succeed())
+
+			gosql.RegisterEngine("sqlite-down", flakySQLite(-1), engine.SQLiteDialect{})
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+			defer cancel()
+
+			_, err = gosql.OpenWith(
+				timeoutCtx,
+				"sqlite-down://"+f.Name(),
+				gosql.WithSkipMigration(true),
+				gosql.WithLogger(&buf),
+				gosql.WithRetry(time.Millisecond, 0, 0),
+			)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("DB open error: not ready: connection refused (context deadline exceeded)"))
+
+			_, err = gosql.OpenWith(
+				ctx,
+				"sqlite-down://"+f.Name()+"?gosql_retry_max_attempts=3",
+				gosql.WithSkipMigration(true),
+				gosql.WithRetry(time.Millisecond, 0, 0),
+			)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("DB open error: not ready: connection refused (3 attempts)"))
+
+			// Errors which are not connection errors are not retried
+			attempts := 0
+			gosql.RegisterEngine("sqlite-broken", brokenSQLite(&attempts), engine.SQLiteDialect{})
+			_, err = gosql.OpenWith(
+				ctx,
+				"sqlite-broken://"+f.Name(),
+				gosql.WithSkipMigration(true),
+				gosql.WithRetry(time.Millisecond, 0, 0),
+			)
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("DB open error: invalid config"))
+			Expect(attempts).To(Equal(1))
+
+			// Attempts are logged in debug mode only
+			buf.Reset()
+			gosql.RegisterEngine("sqlite-flaky-quiet", flakySQLite(1), engine.SQLiteDialect{})
+			db, err = gosql.OpenWith(
+				ctx,
+				"sqlite-flaky-quiet://"+f.Name(),
+				gosql.WithSkipMigration(true),
+				gosql.WithLogger(&buf),
+				gosql.WithRetry(time.Millisecond, 0, 0),
+			)
+			Expect(err).To(Succeed())
+			Expect(buf.String()).To(BeEmpty())
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection with metrics, migrate and render metrics", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			metrics := gosql.NewMetrics(0.5, 60)
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithMetrics(metrics), gosql.WithMaxOpenConns(3))
+			Expect(err).To(Succeed())
+
+			err = db.QueryRow(ctx, sql, 2).Scan(&id, &name)
+			Expect(err).To(Succeed())
+
+			_, err = db.Exec(ctx, "delete from missing")
+			Expect(err).NotTo(Succeed())
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				_, err := tx.Exec(ctx, "update users set name=$1 where id=$2", "Robert", 2)
+				return err
+			})
+			Expect(err).To(Succeed())
+
+			Expect(db.Stats().MaxOpenConnections).To(Equal(3))
+
+			recorder := httptest.NewRecorder()
+			metrics.Handler(db).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+			Expect(recorder.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+
+			body := recorder.Body.String()
+			Expect(body).To(ContainSubstring("# TYPE gosql_pool_open_connections gauge\n"))
+			Expect(body).To(ContainSubstring("\ngosql_pool_max_open_connections 3\n"))
+			Expect(body).To(ContainSubstring("# TYPE gosql_queries_total counter\n"))
+			Expect(body).To(ContainSubstring(`gosql_queries_total{func="Exec",status="ok"} 1` + "\n"))
+			Expect(body).To(ContainSubstring(`gosql_queries_total{func="Exec",status="error"} 1` + "\n"))
+			Expect(body).To(ContainSubstring(`gosql_queries_total{func="QueryRow",status="ok"} 1` + "\n"))
+			Expect(body).To(ContainSubstring(`gosql_queries_total{func="Begin",status="ok"} 1` + "\n"))
+			Expect(body).To(ContainSubstring(`gosql_queries_total{func="Commit",status="ok"} 1` + "\n"))
+			Expect(body).To(ContainSubstring("# TYPE gosql_query_duration_seconds histogram\n"))
+			Expect(body).To(ContainSubstring(`gosql_query_duration_seconds_bucket{func="Exec",le="60"} 2` + "\n"))
+			Expect(body).To(ContainSubstring(`gosql_query_duration_seconds_bucket{func="Exec",le="+Inf"} 2` + "\n"))
+			Expect(body).To(ContainSubstring(`gosql_query_duration_seconds_count{func="Exec"} 2` + "\n"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and use nested transactions", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithDebug(true), gosql.WithLogger(&buf))
+			Expect(err).To(Succeed())
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 3, "Charlie"); err != nil {
+					return err
+				}
+
+				// Failed nested transaction must be rolled back only
+				err := tx.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+					if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 4, "Dave"); err != nil {
+						return err
+					}
+					return errors.New("example")
+				})
+				Expect(err.Error()).To(Equal("example"))
+
+				return tx.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+					if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 5, "Eve"); err != nil {
+						return err
+					}
+					return tx.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+						_, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 6, "Frank")
+						return err
+					})
+				})
+			})
+			Expect(err).To(Succeed())
+
+			var ids []int
+			err = db.Each(ctx, "select id from users order by id", func(ctx context.Context, rows *gosql.Rows) error {
+				var id int
+				if err := rows.Scan(&id); err != nil {
+					return err
+				}
+				ids = append(ids, id)
+				return nil
+			})
+			Expect(err).To(Succeed())
+			Expect(ids).To(Equal([]int{1, 2, 3, 5, 6}))
+
+			Expect(buf.String()).To(ContainSubstring("[func Savepoint] SAVEPOINT gosql_savepoint_1"))
+			Expect(buf.String()).To(ContainSubstring("[func RollbackToSavepoint] ROLLBACK TO SAVEPOINT gosql_savepoint_1"))
+			Expect(buf.String()).To(ContainSubstring("[func ReleaseSavepoint] RELEASE SAVEPOINT gosql_savepoint_3"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and use ambient transaction", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithDebug(true), gosql.WithLogger(&buf))
+			Expect(err).To(Succeed())
+
+			// Repository code uses engine only
+			insertUser := func(ctx context.Context, id int, name string) error {
+				_, err := db.Exec(ctx, "insert into users (id, name) values ($1, $2)", id, name)
+				return err
+			}
+			countUsers := func(ctx context.Context) (count int) {
+				Expect(db.QueryRow(ctx, "select count(*) from users").Scan(&count)).To(Succeed())
+				return count
+			}
+
+			Expect(gosql.TxFromContext(ctx)).To(BeNil())
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				Expect(gosql.TxFromContext(ctx)).To(Equal(tx))
+				if err := insertUser(ctx, 3, "Charlie"); err != nil {
+					return err
+				}
+				Expect(countUsers(ctx)).To(Equal(3))
+				return errors.New("example")
+			})
+			Expect(err.Error()).To(Equal("example"))
+			Expect(countUsers(ctx)).To(Equal(2))
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				if err := insertUser(ctx, 3, "Charlie"); err != nil {
+					return err
+				}
+
+				// Transaction of engine joins ambient transaction by savepoint
+				err := db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+					if err := insertUser(ctx, 4, "Dave"); err != nil {
+						return err
+					}
+					return errors.New("example")
+				})
+				Expect(err.Error()).To(Equal("example"))
+				return nil
+			})
+			Expect(err).To(Succeed())
+			Expect(countUsers(ctx)).To(Equal(3))
+
+			tx, err := db.Begin(ctx, nil)
+			Expect(err).To(Succeed())
+			Expect(insertUser(gosql.WithTx(ctx, tx), 4, "Dave")).To(Succeed())
+			Expect(tx.Rollback()).To(Succeed())
+			Expect(countUsers(ctx)).To(Equal(3))
+
+			Expect(buf.String()).To(ContainSubstring("[func Savepoint] SAVEPOINT gosql_savepoint_1"))
+
+			// Statements and Ping join ambient transaction
+			insertStmt, err := db.Prepare(ctx, "insert into users (id, name) values ($1, $2)")
+			Expect(err).To(Succeed())
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				if err := db.Ping(ctx); err != nil {
+					return err
+				}
+				if _, err := insertStmt.Exec(ctx, 4, "Dave"); err != nil {
+					return err
+				}
+				stmt, err := db.Prepare(ctx, "insert into users (id, name) values ($1, $2)")
+				if err != nil {
+					return err
+				}
+				if _, err := stmt.Exec(ctx, 5, "Eve"); err != nil {
+					return err
+				}
+				Expect(countUsers(ctx)).To(Equal(5))
+				return errors.New("example")
+			})
+			Expect(err.Error()).To(Equal("example"))
+			Expect(countUsers(ctx)).To(Equal(3))
+			Expect(buf.String()).To(ContainSubstring("[TX] [func Ping]"))
+			Expect(buf.String()).To(ContainSubstring("[TX] [func Prepare]"))
+
+			// Statement is prepared once on dedicated connection of transaction
+			buf.Reset()
+			err = db.TransactionWith(ctx, &gosql.TxOptions{Isolation: gosql.LevelSerializable}, func(ctx context.Context, tx *gosql.Tx) error {
+				Expect(tx.Stmt(ctx, insertStmt)).To(BeIdenticalTo(tx.Stmt(ctx, insertStmt)))
+				for id := 4; id <= 5; id++ {
+					if _, err := insertStmt.Exec(ctx, id, "Dave"); err != nil {
+						return err
+					}
+				}
+				Expect(countUsers(ctx)).To(Equal(5))
+				return errors.New("example")
+			})
+			Expect(err.Error()).To(Equal("example"))
+			Expect(strings.Count(buf.String(), "[func Prepare]")).To(Equal(1))
+			Expect(insertStmt.Close()).To(Succeed())
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and rollback transaction on panic", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+			var events []gosql.QueryEvent
+
+			db, err := gosql.OpenWith(
+				ctx,
+				"sqlite://"+f.Name(),
+				gosql.WithMigrations(migrationsDir),
+				gosql.WithLogger(&buf),
+				gosql.WithHook(func(ctx context.Context, event gosql.QueryEvent) {
+					if event.Func == "Transaction" {
+						events = append(events, event)
+					}
+				}),
+			)
+			Expect(err).To(Succeed())
+
+			Expect(func() {
+				_ = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+					if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 3, "Charlie"); err != nil {
+						return err
+					}
+					panic("example")
+				})
+			}).To(PanicWith("example"))
+			Expect(buf.String()).To(BeEmpty())
+			Expect(events).To(HaveLen(1))
+			Expect(events[0].Err.Error()).To(Equal("panic: example"))
+
+			var count int
+			Expect(db.QueryRow(ctx, "select count(*) from users").Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(2))
+
+			// Panic inside savepoint rolls back savepoint only
+			var rollbacks []string
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 3, "Charlie"); err != nil {
+					return err
+				}
+				Expect(func() {
+					_ = tx.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+						tx.OnRollback(func(ctx context.Context, err error) {
+							rollbacks = append(rollbacks, err.Error())
+						})
+						if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 4, "Dave"); err != nil {
+							return err
+						}
+						panic("example")
+					})
+				}).To(PanicWith("example"))
+				return nil
+			})
+			Expect(err).To(Succeed())
+			Expect(rollbacks).To(Equal([]string{"panic: example"}))
+			Expect(events).To(HaveLen(2))
+			Expect(events[1].Tx).To(BeTrue())
+
+			Expect(db.QueryRow(ctx, "select count(*) from users").Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(3))
+
+			// Transaction must not be left active
+			sctx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			Expect(db.Shutdown(sctx)).To(Succeed())
+		})
+
+		It("open connection, migrate and rollback transaction on cancelled context", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir))
+			Expect(err).To(Succeed())
+
+			cctx, cancel := context.WithCancel(ctx)
+			err = db.Transaction(cctx, func(ctx context.Context, tx *gosql.Tx) error {
+				if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 3, "Charlie"); err != nil {
+					return err
+				}
+				cancel()
+				return nil
+			})
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+
+			var count int
+			Expect(db.QueryRow(ctx, "select count(*) from users").Scan(&count)).To(Succeed())
+			Expect(count).To(Equal(2))
+
+			sctx, scancel := context.WithTimeout(ctx, time.Second)
+			defer scancel()
+			Expect(db.Shutdown(sctx)).To(Succeed())
+		})
+
+		It("open connection, migrate and run commit and rollback callbacks", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithDebug(true), gosql.WithLogger(&buf))
+			Expect(err).To(Succeed())
+
+			var events []string
+			onRollback := func(name string) func(ctx context.Context, err error) {
+				return func(ctx context.Context, err error) {
+					events = append(events, fmt.Sprintf("%s rollback: %v", name, err))
+				}
+			}
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				tx.OnCommit(func(ctx context.Context) { panic("example") })
+				tx.OnCommit(func(ctx context.Context) { events = append(events, "commit") })
+				tx.OnRollback(onRollback("tx"))
+				_, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 3, "Charlie")
+				Expect(events).To(BeEmpty())
+				return err
+			})
+			Expect(err).To(Succeed())
+			Expect(events).To(Equal([]string{"commit"}))
+			Expect(buf.String()).To(ContainSubstring("[TX] [func OnCommit] (empty) (panic: example)"))
+
+			events = nil
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				tx.OnCommit(func(ctx context.Context) { events = append(events, "commit") })
+				tx.OnRollback(onRollback("tx"))
+				return errors.New("example")
+			})
+			Expect(err.Error()).To(Equal("example"))
+			Expect(events).To(Equal([]string{"tx rollback: example"}))
+
+			// Callbacks of rolled back savepoint are finished with it
+			events = nil
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				tx.OnCommit(func(ctx context.Context) { events = append(events, "commit") })
+				tx.OnRollback(onRollback("tx"))
+				err := tx.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+					tx.OnCommit(func(ctx context.Context) { events = append(events, "savepoint commit") })
+					tx.OnRollback(onRollback("savepoint"))
+					return errors.New("example")
+				})
+				Expect(err.Error()).To(Equal("example"))
+				return nil
+			})
+			Expect(err).To(Succeed())
+			Expect(events).To(Equal([]string{"savepoint rollback: example", "commit"}))
+
+			events = nil
+			tx, err := db.Begin(ctx, nil)
+			Expect(err).To(Succeed())
+			tx.OnRollback(onRollback("tx"))
+			Expect(tx.Rollback()).To(Succeed())
+			Expect(events).To(Equal([]string{"tx rollback: <nil>"}))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and use prepared statements", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithDebug(true), gosql.WithLogger(&buf))
+			Expect(err).To(Succeed())
+
+			_, err = db.Prepare(ctx, "select missing from users")
+			Expect(err.Error()).To(Equal("Prepare: no such column: missing (query: select missing from users)"))
+
+			_, err = db.Prepare(ctx, "select name from users where id = $0")
+			Expect(err.Error()).To(Equal("Prepare: invalid placeholder $0 (query: select name from users where id = ?0)"))
+
+			selectUser, err := db.Prepare(ctx, sql)
+			Expect(err).To(Succeed())
+			Expect(selectUser.QueryRow(ctx, 2).Scan(&id, &name)).To(Succeed())
+			Expect(name).To(Equal("Bob"))
+			Expect(buf.String()).To(ContainSubstring("[func StmtQueryRow] select id, name from users where id=?1"))
+
+			insertUser, err := db.Prepare(ctx, "insert into users (name, id) values ($2, $1)")
+			Expect(err).To(Succeed())
+
+			err = db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+				if err := tx.Ping(ctx); err != nil {
+					return err
+				}
+				if _, err := tx.Stmt(ctx, insertUser).Exec(ctx, 3, "Charlie"); err != nil {
+					return err
+				}
+				stmt, err := tx.Prepare(ctx, "select count(*) from users where id > $1")
+				if err != nil {
+					return err
+				}
+				var count int
+				if err := stmt.QueryRow(ctx, 0).Scan(&count); err != nil {
+					return err
+				}
+				Expect(count).To(Equal(3))
+				return errors.New("example")
+			})
+			Expect(err.Error()).To(Equal("example"))
+			Expect(buf.String()).To(ContainSubstring("[TX] [func StmtExec] insert into users (name, id) values (?2, ?1) ([3 Charlie])"))
+
+			_, err = insertUser.Exec(ctx, 3, "Charlie")
+			Expect(err).To(Succeed())
+
+			var names []string
+			selectNames, err := db.Prepare(ctx, "select name from users where id >= $1 order by id")
+			Expect(err).To(Succeed())
+			res, err := selectNames.Query(ctx, 2)
+			Expect(err).To(Succeed())
+			for res.Next() {
+				Expect(res.Scan(&name)).To(Succeed())
+				names = append(names, name)
+			}
+			Expect(res.Close()).To(Succeed())
+			Expect(names).To(Equal([]string{"Bob", "Charlie"}))
+
+			Expect(selectUser.Close()).To(Succeed())
+			Expect(insertUser.Close()).To(Succeed())
+			Expect(selectNames.Close()).To(Succeed())
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and use transaction options", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithMaxOpenConns(1))
+			Expect(err).To(Succeed())
+
+			readOnly := &gosql.TxOptions{ReadOnly: true}
+
+			err = db.TransactionWith(ctx, readOnly, func(ctx context.Context, tx *gosql.Tx) error {
+				if err := tx.QueryRow(ctx, sql, 2).Scan(&id, &name); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, "delete from users where id=$1", 2)
+				return err
+			})
+			Expect(err.Error()).To(ContainSubstring("attempt to write a readonly database"))
+			Expect(name).To(Equal("Bob"))
+
+			// Read-only mode must be reset after transaction
+			err = db.TransactionWith(ctx, &gosql.TxOptions{Isolation: gosql.LevelSerializable}, func(ctx context.Context, tx *gosql.Tx) error {
+				_, err := tx.Exec(ctx, "delete from users where id=$1", 2)
+				return err
+			})
+			Expect(err).To(Succeed())
+
+			err = db.TransactionWith(ctx, readOnly, func(ctx context.Context, tx *gosql.Tx) error {
+				return nil
+			})
+			Expect(err).To(Succeed())
+			_, err = db.Exec(ctx, "delete from users where id=$1", 1)
+			Expect(err).To(Succeed())
+
+			err = db.TransactionWith(ctx, &gosql.TxOptions{Isolation: gosql.LevelReadCommitted}, func(ctx context.Context, tx *gosql.Tx) error {
+				return nil
+			})
+			Expect(err.Error()).To(Equal("isolation level is not supported by sqlite: Read Committed"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and reset read-only transaction on cancelled context", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			var buf bytes.Buffer
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithMaxOpenConns(1), gosql.WithDebug(true), gosql.WithLogger(&buf))
+			Expect(err).To(Succeed())
+
+			cctx, cancel := context.WithCancel(ctx)
+			err = db.TransactionWith(cctx, &gosql.TxOptions{ReadOnly: true}, func(ctx context.Context, tx *gosql.Tx) error {
+				cancel()
+				return nil
+			})
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+
+			// Connection must be writable again
+			_, err = db.Exec(ctx, "delete from users where id=$1", 2)
+			Expect(err).To(Succeed())
+
+			err = db.TransactionWith(ctx, &gosql.TxOptions{Isolation: gosql.LevelSerializable}, func(ctx context.Context, tx *gosql.Tx) error {
+				_, err := tx.Exec(ctx, "delete from users where id=$1", 1)
+				return err
+			})
+			Expect(err).To(Succeed())
+
+			Expect(buf.String()).To(ContainSubstring("[func Begin] BEGIN DEFERRED"))
+			Expect(buf.String()).To(ContainSubstring("[func Reset] PRAGMA query_only = OFF"))
+			Expect(buf.String()).To(ContainSubstring("[func Begin] BEGIN IMMEDIATE"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and shutdown gracefully", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			started := make(chan struct{})
+			proceed := make(chan struct{})
+			committed := make(chan error, 1)
+			go func() {
+				committed <- db.Transaction(ctx, func(ctx context.Context, tx *gosql.Tx) error {
+					close(started)
+					<-proceed
+					_, err := tx.Exec(ctx, "update users set name=$1 where id=$2", "Robert", 2)
+					return err
+				})
+			}()
+			<-started
+
+			rows, err := db.Query(ctx, "select id from users")
+			Expect(err).To(Succeed())
+
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- db.Shutdown(ctx)
+			}()
+
+			Eventually(func() error {
+				_, err := db.Exec(ctx, "select 1")
+				return err
+			}).Should(Equal(gosql.ErrShutdown))
+			Expect(db.QueryRow(ctx, "select 1").Scan(&id)).To(Equal(gosql.ErrShutdown))
+			Consistently(stopped, "20ms").ShouldNot(Receive())
+
+			close(proceed)
+			Expect(<-committed).To(Succeed())
+			Consistently(stopped, "20ms").ShouldNot(Receive())
+
+			Expect(rows.Close()).To(Succeed())
+			Eventually(stopped).Should(Receive(BeNil()))
+		})
+
+		It("open connection, migrate and shutdown by timeout", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			tx, err := db.Begin(ctx, nil)
+			Expect(err).To(Succeed())
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+
+			err = db.Shutdown(timeoutCtx)
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+			_, err = db.Exec(ctx, "select 1")
+			Expect(err).To(Equal(gosql.ErrShutdown))
+
+			_ = tx.Rollback()
+		})
+
+		It("open connection, migrate and shutdown after rows read to end", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.OpenWith(ctx, "sqlite://"+f.Name(), gosql.WithMigrations(migrationsDir), gosql.WithQueryTimeout(time.Minute))
+			Expect(err).To(Succeed())
+
+			// Failed query is finished by Err
+			Expect(db.QueryRow(ctx, "select missing from users").Err()).NotTo(Succeed())
+
+			// Row is usable after successful Err
+			row := db.QueryRow(ctx, sql, 1)
+			Expect(row.Err()).To(Succeed())
+			Expect(row.Scan(&id, &name)).To(Succeed())
+			Expect(name).To(Equal("Alice"))
+
+			// Rows are finished after last row without Close
+			rows, err := db.Query(ctx, "select id from users")
+			Expect(err).To(Succeed())
+			count := 0
+			for rows.Next() {
+				count++
+			}
+			Expect(rows.Err()).To(Succeed())
+			Expect(count).To(Equal(2))
+
+			timeoutCtx, cancel := context.WithTimeout(ctx, time.Second)
+			defer cancel()
+			Expect(db.Shutdown(timeoutCtx)).To(Succeed())
+		})
+
+		It("open replicated connection, migrate primary and select data", func() {
+			primary, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			primary.Close()
+
+			replica, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			replica.Close()
+
+			db, err := gosql.OpenReplicated(
+				ctx,
+				"sqlite://"+primary.Name(),
+				[]string{"sqlite://" + replica.Name()},
+				gosql.WithMigrations(migrationsDir),
+				gosql.WithReplicaPolicy(gosql.LeastConnections),
+			)
+			Expect(err).To(Succeed())
+
+			// Migrations are applied to primary only
+			err = db.QueryRow(ctx, sql, 2).Scan(&id, &name)
+			Expect(err.Error()).To(Equal("QueryRow: no such table: users (query: select id, name from users where id=?1)"))
+
+			err = db.QueryRow(gosql.UsePrimary(ctx), sql, 2).Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(name).To(Equal("Bob"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("open connection, migrate and retry transaction", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), migrationsDir, false, false)
+			Expect(err).To(Succeed())
+
+			errConflict := errors.New("conflict")
+			policy := gosql.RetryPolicy{
+				MaxAttempts: 3,
+				MinBackoff:  time.Millisecond,
+				MaxBackoff:  time.Millisecond,
+				Retryable: func(err error) bool {
+					return errors.Is(err, errConflict)
+				},
+			}
+
+			var txs []*gosql.Tx
+			err = db.TransactionRetry(ctx, policy, func(ctx context.Context, tx *gosql.Tx) error {
+				txs = append(txs, tx)
+				if _, err := tx.Exec(ctx, "insert into users (id, name) values ($1, $2)", 3, "Charlie"); err != nil {
+					return err
+				}
+				if len(txs) < 3 {
+					return errConflict
+				}
+				return nil
+			})
+			Expect(err).To(Succeed())
+			Expect(txs).To(HaveLen(3))
+			Expect(txs[0]).NotTo(BeIdenticalTo(txs[1]))
+			Expect(txs[1]).NotTo(BeIdenticalTo(txs[2]))
+
+			// Failed attempts must be rolled back
+			var size int
+			err = db.QueryRow(ctx, "select count(*) from users where id=3").Scan(&size)
+			Expect(err).To(Succeed())
+			Expect(size).To(Equal(1))
+
+			// Attempts are limited
+			attempts := 0
+			err = db.TransactionRetry(ctx, policy, func(ctx context.Context, tx *gosql.Tx) error {
+				attempts++
+				return errConflict
+			})
+			Expect(err).To(Equal(errConflict))
+			Expect(attempts).To(Equal(3))
+
+			// Not retryable errors are returned at once
+			attempts = 0
+			err = db.TransactionRetry(ctx, policy, func(ctx context.Context, tx *gosql.Tx) error {
+				attempts++
+				return errors.New("example")
+			})
+			Expect(err.Error()).To(Equal("example"))
+			Expect(attempts).To(Equal(1))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
+		It("wrap existing connection, migrate and select data", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			conn, err := openSQLite(&url.URL{Path: f.Name()})
+			Expect(err).To(Succeed())
+
+			db, err := gosql.Wrap(conn, "sqlite", gosql.WithMigrations(migrationsDir))
+			Expect(err).To(Succeed())
+
+			err = db.QueryRow(ctx, sql, 2).Scan(&id, &name)
+			Expect(err).To(Succeed())
+			Expect(id).To(Equal(2))
+			Expect(name).To(Equal("Bob"))
+
+			// Applied migrations must be skipped
+			db, err = gosql.Wrap(conn, "sqlite", gosql.WithMigrations(migrationsDir))
+			Expect(err).To(Succeed())
+
+			var size int
+			err = db.QueryRow(ctx, "select count(*) from users").Scan(&size)
+			Expect(err).To(Succeed())
+			Expect(size).To(Equal(2))
+
+			_, err = gosql.Wrap(conn, "example")
+			Expect(err).NotTo(Succeed())
+			Expect(err.Error()).To(Equal("unsupported dialect: example"))
+
+			Expect(db.Close()).To(Succeed())
+		})
+
 		It("open connection and skip migration", func() {
 			f, err := os.CreateTemp("", "go-sqlite-test-")
 			Expect(err).To(Succeed())
@@ -523,11 +1552,68 @@ var _ = Describe("gosql", func() {
 
 			var size int
 			err = db.QueryRow(ctx, "select count(*) from users").Scan(&size)
-			Expect(err.Error()).To(Equal("no such table: users"))
+			Expect(err.Error()).To(Equal("QueryRow: no such table: users (query: select count(*) from users)"))
+		})
+
+		It("open connection and wrap query errors", func() {
+			f, err := os.CreateTemp("", "go-sqlite-test-")
+			Expect(err).To(Succeed())
+			f.Close()
+
+			db, err := gosql.Open("sqlite://"+f.Name(), "", true, false)
+			Expect(err).To(Succeed())
+
+			var size int
+			err = db.QueryRow(ctx, "select count(*) from users").Scan(&size)
+
+			var qerr *gosql.QueryError
+			Expect(errors.As(err, &qerr)).To(BeTrue())
+			Expect(qerr.Func).To(Equal("QueryRow"))
+			Expect(qerr.Query).To(Equal("select count(*) from users"))
+			Expect(qerr.Args).To(Equal(0))
+			Expect(qerr.Code).To(Equal("1"))
+			Expect(qerr.Err.Error()).To(Equal("no such table: users"))
+
+			_, err = db.Exec(ctx, "delete from users where id=$1", 1)
+			Expect(err.Error()).To(Equal("Exec: no such table: users (query: delete from users where id=?1)"))
+			Expect(errors.As(err, &qerr)).To(BeTrue())
+			Expect(qerr.Args).To(Equal(1))
+
+			_, err = db.Query(ctx, "select * from users where id=$1", 1)
+			Expect(err.Error()).To(Equal("Query: no such table: users (query: select * from users where id=?1)"))
+
+			// Empty result is not an error of query
+			err = db.QueryRow(ctx, "select 1 where 1=0").Scan(&size)
+			Expect(err.Error()).To(Equal("sql: no rows in result set"))
+
+			Expect(db.Close()).To(Succeed())
 		})
 	})
 })
 
+func openSQLite(dbURL *url.URL) (*sql.DB, error) {
+	return sql.Open("sqlite3", dbURL.Path)
+}
+
+// flakySQLite returns factory which fails given times, negative value means
+// always
+func flakySQLite(failures int) func(dbURL *url.URL) (*sql.DB, error) {
+	return func(dbURL *url.URL) (*sql.DB, error) {
+		if failures != 0 {
+			failures--
+			return nil, fmt.Errorf("not ready: %w", syscall.ECONNREFUSED)
+		}
+		return openSQLite(dbURL)
+	}
+}
+
+func brokenSQLite(attempts *int) func(dbURL *url.URL) (*sql.DB, error) {
+	return func(dbURL *url.URL) (*sql.DB, error) {
+		*attempts++
+		return nil, errors.New("invalid config")
+	}
+}
+
 func TestSuite(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "gosql")
@@ -0,0 +1,228 @@
+package gosql
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vladimirok5959/golang-sql/gosql/common"
+	"github.com/vladimirok5959/golang-sql/gosql/engine"
+)
+
+type options struct {
+	connMaxIdleTime time.Duration
+	connMaxLifetime time.Duration
+	connectTimeout  time.Duration
+	debug           bool
+	healthInterval  time.Duration
+	hooks           []common.Hook
+	logger          io.Writer
+	maxIdleConns    int
+	maxOpenConns    int
+	migrationsDir   string
+	migrationsFS    fs.FS
+	queryTimeout    time.Duration
+	replicaPolicy   engine.ReplicaPolicy
+	retryAttempts   int
+	retryInterval   time.Duration
+	retryMax        time.Duration
+	skipMigration   bool
+}
+
+func (o *options) methods(db *sql.DB, dialect common.Dialect, driver string) *common.DBMethods {
+	return &common.DBMethods{
+		DB:           db,
+		Debug:        o.debug,
+		Dialect:      dialect,
+		Driver:       driver,
+		Hooks:        o.hooks,
+		Logger:       o.logger,
+		QueryTimeout: o.queryTimeout,
+	}
+}
+
+// paramOptions converts gosql specific URL query params to options
+func paramOptions(params url.Values) ([]Option, error) {
+	var opts []Option
+	for name, values := range params {
+		value := values[len(values)-1]
+		switch name {
+		case "gosql_conn_max_idle_time", "gosql_conn_max_lifetime", "gosql_connect_timeout", "gosql_query_timeout", "gosql_retry_interval", "gosql_retry_max_interval":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL param %s: %w", name, err)
+			}
+			switch name {
+			case "gosql_conn_max_idle_time":
+				opts = append(opts, WithConnMaxIdleTime(d))
+			case "gosql_conn_max_lifetime":
+				opts = append(opts, WithConnMaxLifetime(d))
+			case "gosql_connect_timeout":
+				opts = append(opts, WithConnectTimeout(d))
+			case "gosql_query_timeout":
+				opts = append(opts, WithQueryTimeout(d))
+			case "gosql_retry_interval":
+				opts = append(opts, func(o *options) { o.retryInterval = d })
+			case "gosql_retry_max_interval":
+				opts = append(opts, func(o *options) { o.retryMax = d })
+			}
+		case "gosql_retry_max_attempts":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL param %s: %w", name, err)
+			}
+			opts = append(opts, func(o *options) { o.retryAttempts = n })
+		case "gosql_debug", "gosql_skip_migration":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL param %s: %w", name, err)
+			}
+			if name == "gosql_debug" {
+				opts = append(opts, WithDebug(b))
+			} else {
+				opts = append(opts, WithSkipMigration(b))
+			}
+		case "gosql_max_idle_conns", "gosql_max_open_conns":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid URL param %s: %w", name, err)
+			}
+			if name == "gosql_max_idle_conns" {
+				opts = append(opts, WithMaxIdleConns(n))
+			} else {
+				opts = append(opts, WithMaxOpenConns(n))
+			}
+		case "gosql_migrations":
+			opts = append(opts, WithMigrations(value))
+		default:
+			return nil, fmt.Errorf("unsupported URL param: %s", name)
+		}
+	}
+	return opts, nil
+}
+
+// Option configures engine created by OpenWith or Wrap
+type Option func(*options)
+
+// WithConnMaxIdleTime sets max time of connection being idle
+func WithConnMaxIdleTime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxIdleTime = d
+	}
+}
+
+// WithConnMaxLifetime sets max time of connection being reused
+func WithConnMaxLifetime(d time.Duration) Option {
+	return func(o *options) {
+		o.connMaxLifetime = d
+	}
+}
+
+// WithConnectTimeout makes OpenWith to ping database with given timeout
+func WithConnectTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.connectTimeout = d
+	}
+}
+
+// WithDebug enables SQL queries logging
+func WithDebug(debug bool) Option {
+	return func(o *options) {
+		o.debug = debug
+	}
+}
+
+// WithHealthCheckInterval makes OpenReplicated to ping replicas with given
+// interval (DefaultHealthInterval by default), replicas which don't answer are
+// skipped until they answer again, negative interval disables health checks
+func WithHealthCheckInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.healthInterval = d
+	}
+}
+
+// WithHook adds hook which is called after each query
+func WithHook(hook common.Hook) Option {
+	return func(o *options) {
+		o.hooks = append(o.hooks, hook)
+	}
+}
+
+// WithLogger sets debug messages destination, os.Stdout by default
+func WithLogger(w io.Writer) Option {
+	return func(o *options) {
+		o.logger = w
+	}
+}
+
+// WithMaxIdleConns sets max count of idle connections
+func WithMaxIdleConns(n int) Option {
+	return func(o *options) {
+		o.maxIdleConns = n
+	}
+}
+
+// WithMaxOpenConns sets max count of open connections
+func WithMaxOpenConns(n int) Option {
+	return func(o *options) {
+		o.maxOpenConns = n
+	}
+}
+
+// WithMetrics makes engine to report calls to metrics
+func WithMetrics(m *common.Metrics) Option {
+	return WithHook(m.Hook())
+}
+
+// WithMigrations applies migrations from directory
+func WithMigrations(dir string) Option {
+	return func(o *options) {
+		o.migrationsDir = dir
+	}
+}
+
+// WithMigrationsFS applies migrations from file system, for example embed.FS
+func WithMigrationsFS(fsys fs.FS) Option {
+	return func(o *options) {
+		o.migrationsFS = fsys
+	}
+}
+
+// WithQueryTimeout limits each Exec, Query and QueryRow call
+func WithQueryTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.queryTimeout = d
+	}
+}
+
+// WithReplicaPolicy sets how OpenReplicated chooses replica for read query,
+// RoundRobin by default
+func WithReplicaPolicy(policy ReplicaPolicy) Option {
+	return func(o *options) {
+		o.replicaPolicy = policy
+	}
+}
+
+// WithRetry makes OpenWith to retry connection and migration failed by
+// connection error until database answers Ping, context is done or
+// maxAttempts are made, delay starts from interval and is doubled for each
+// next attempt up to maxInterval (zero maxInterval and maxAttempts mean no
+// limit)
+func WithRetry(interval, maxInterval time.Duration, maxAttempts int) Option {
+	return func(o *options) {
+		o.retryAttempts = maxAttempts
+		o.retryInterval = interval
+		o.retryMax = maxInterval
+	}
+}
+
+// WithSkipMigration disables migrations in OpenWith
+func WithSkipMigration(skip bool) Option {
+	return func(o *options) {
+		o.skipMigration = skip
+	}
+}